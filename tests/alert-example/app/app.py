@@ -1,9 +1,40 @@
+import argparse
+import asyncio
+import base64
+import collections
+import contextlib
+import contextvars
+import difflib
+import glob
+import gzip
+import hashlib
+import hmac
+import io
+import json
+import logging
+import math
+import multiprocessing
 import os
+import random
+import re
 import sys
 import time
 import threading
 import signal
-from fastapi import FastAPI, Response
+import socket
+import ssl
+import traceback
+import tracemalloc
+import uuid
+import uvicorn
+import yaml
+from dataclasses import dataclass
+from datetime import date, datetime, timedelta, timezone
+from typing import Optional
+from fastapi import APIRouter, FastAPI, Request, Response
+from fastapi.responses import JSONResponse, StreamingResponse
+from starlette.background import BackgroundTask
+from starlette.routing import Match
 
 # OpenTelemetry setup
 from opentelemetry import trace
@@ -20,17 +51,618 @@ import requests
 from requests.sessions import Session
 
 
+# Build metadata, baked into the image from the Dockerfile's build args
+version = os.getenv("APP_VERSION") or "dev"
+commit = os.getenv("APP_COMMIT") or "none"
+build_date = os.getenv("APP_BUILD_DATE") or "unknown"
+
+
+_request_id: contextvars.ContextVar = contextvars.ContextVar("request_id", default=None)
+
+
+def request_id_from_context() -> Optional[str]:
+    """The X-Request-ID of the request being handled, or None outside a request."""
+    return _request_id.get()
+
+
+def _json_logs() -> bool:
+    return os.getenv("LOG_FORMAT", "").lower() == "json"
+
+
+def log_event(level: str, msg: str, **fields) -> None:
+    """Emit one log line; a JSON object when LOG_FORMAT=json, else "LEVEL: msg key=value ..."."""
+    request_id = request_id_from_context()
+    if request_id is not None:
+        fields.setdefault("request_id", request_id)
+    stream = sys.stdout if level == "INFO" else sys.stderr
+    if _json_logs():
+        ts = datetime.now(timezone.utc).isoformat(timespec="seconds").replace("+00:00", "Z")
+        line = json.dumps({"ts": ts, "level": level, "msg": msg, **fields}, default=str)
+    else:
+        line = f"{level}: {msg}" + "".join(f" {key}={value}" for key, value in fields.items())
+    print(line, file=stream, flush=True)
+
+
+def _env_flag(name: str, default: bool = False) -> bool:
+    raw = os.getenv(name)
+    if raw is None or raw.strip() == "":
+        return default
+    return raw.strip().lower() in ("1", "true", "yes", "on")
+
+
+def _env_number(name: str, default, cast):
+    raw = os.getenv(name)
+    if raw is None or raw.strip() == "":
+        return default
+    try:
+        value = cast(raw)
+    except ValueError:
+        value = -1
+    if value < 0:
+        log_event("WARN", f"invalid {name}={raw!r}, using {default}")
+        return default
+    return value
+
+
+def _env_int(name: str, default: int = 0) -> int:
+    """Non-negative integer from the environment; default when unset or invalid."""
+    return _env_number(name, default, int)
+
+
+def _env_float(name: str, default: float = 0.0) -> float:
+    """Non-negative number from the environment; default when unset or invalid."""
+    return _env_number(name, default, float)
+
+
+# Set from --config; takes precedence over CONFIG_PATH
+config_path_override: Optional[str] = None
+
+
 def get_config_path() -> str:
+    if config_path_override:
+        return config_path_override
     p = os.getenv("CONFIG_PATH")
     if not p:
         return "/etc/alert-example/config.yaml"
     return p
 
 
+def get_crash_keywords() -> list:
+    """Keywords that arm the crash, from comma-separated CRASH_KEYWORD (default "Crash").
+
+    An empty CRASH_KEYWORD disables crashing entirely.
+    """
+    raw = os.getenv("CRASH_KEYWORD", "Crash")
+    return [k.strip() for k in raw.split(",") if k.strip()]
+
+
+def _hard_exit(code: int) -> None:
+    """Terminate immediately like Go's os.Exit, flushing buffered log lines first."""
+    sys.stdout.flush()
+    sys.stderr.flush()
+    os._exit(code)
+
+
+# Presets selected by SCENARIO; an explicitly set env var still overrides its field
+SCENARIOS = {
+    "healthy": {"RESPONSE_DELAY_MS": "0", "ERROR_RATE": "0", "CRASH_ARMED": "false"},
+    "crashloop": {"RESPONSE_DELAY_MS": "0", "ERROR_RATE": "0", "CRASH_ARMED": "true"},
+    "slow": {"RESPONSE_DELAY_MS": "2000", "ERROR_RATE": "0", "CRASH_ARMED": "false"},
+    "flaky": {"RESPONSE_DELAY_MS": "0", "ERROR_RATE": "0.3", "CRASH_ARMED": "false"},
+}
+
+
+def get_scenario() -> str:
+    return os.getenv("SCENARIO", "").strip().lower()
+
+
+def scenario_env(name: str) -> Optional[str]:
+    """The env var if set, else the selected scenario's value for it (None when neither)."""
+    raw = os.getenv(name)
+    if raw is not None and raw.strip() != "":
+        return raw
+    return SCENARIOS.get(get_scenario(), {}).get(name)
+
+
+def crash_armed_by_scenario() -> bool:
+    """CRASH_ARMED=true crashes on any config, keyword or not."""
+    raw = scenario_env("CRASH_ARMED")
+    return raw is not None and raw.strip().lower() in ("1", "true", "yes", "on")
+
+
+# Every termination path goes through exit_func so tests can substitute a recorder
+exit_func = _hard_exit
+
+
+def get_crash_exit_code() -> int:
+    """Exit code for crash-keyword terminations, from CRASH_EXIT_CODE (1-255, default 1)."""
+    raw = os.getenv("CRASH_EXIT_CODE")
+    if not raw:
+        return 1
+    try:
+        code = int(raw)
+    except ValueError:
+        code = 0
+    if not 1 <= code <= 255:
+        log_event("WARN", f"invalid CRASH_EXIT_CODE={raw!r}, using 1")
+        return 1
+    return code
+
+
+CRASH_MODES = ("exit", "degrade")
+
+
+def get_crash_mode() -> str:
+    """What a crash keyword does, from CRASH_MODE: "exit" (default) terminates, "degrade" fails /healthz."""
+    raw = os.getenv("CRASH_MODE", "").strip().lower()
+    if not raw:
+        return "exit"
+    if raw not in CRASH_MODES:
+        log_event("WARN", f"invalid CRASH_MODE={raw!r}, using exit")
+        return "exit"
+    return raw
+
+
+def matched_crash_keywords(content: str) -> list:
+    return [keyword for keyword in get_crash_keywords() if keyword in content]
+
+
+def should_crash(content: str) -> bool:
+    return crash_armed_by_scenario() or bool(matched_crash_keywords(content))
+
+
+class _Readiness:
+    """Readiness derived from config reads: ready once a read succeeded, unless the latest failed.
+
+    With MIN_UPTIME_SECONDS set it also stays not ready until the process has been up that long,
+    and likewise for the randomized warm-up given to set_warmup.
+    """
+
+    def __init__(self, clock=time.monotonic) -> None:
+        self._lock = threading.Lock()
+        self._clock = clock
+        self._started = clock()
+        self._warmup = 0.0
+        self._ever_loaded = False
+        self._last_read_ok = False
+        self._draining = False
+        self._forced: Optional[bool] = None
+
+    def record_config_read(self, ok: bool) -> None:
+        with self._lock:
+            self._last_read_ok = ok
+            if ok:
+                self._ever_loaded = True
+
+    def set_draining(self, draining: bool) -> None:
+        with self._lock:
+            self._draining = draining
+
+    def draining(self) -> bool:
+        with self._lock:
+            return self._draining
+
+    def config_status(self) -> tuple:
+        """(ever_loaded, last_read_ok) for the detailed /healthz payload."""
+        with self._lock:
+            return self._ever_loaded, self._last_read_ok
+
+    def set_warmup(self, seconds: float) -> None:
+        with self._lock:
+            self._warmup = seconds
+
+    def set_override(self, forced: Optional[bool]) -> None:
+        """Force readiness on or off regardless of the automatic checks; None returns to auto."""
+        with self._lock:
+            self._forced = forced
+
+    def override(self) -> Optional[bool]:
+        with self._lock:
+            return self._forced
+
+    def toggle_draining(self) -> bool:
+        """Flip drain mode and return the new state."""
+        with self._lock:
+            self._draining = not self._draining
+            return self._draining
+
+    def check(self) -> tuple:
+        """Return (ready, reason)."""
+        with self._lock:
+            if self._draining:
+                return False, "draining"
+            if not self._ever_loaded:
+                return False, "config has not been loaded yet"
+            if not self._last_read_ok:
+                return False, "most recent config read failed"
+            min_uptime = _env_float("MIN_UPTIME_SECONDS")
+            uptime = self._clock() - self._started
+            if uptime < min_uptime:
+                return False, f"warming up: up {uptime:.1f}s of MIN_UPTIME_SECONDS={min_uptime:g}"
+            if uptime < self._warmup:
+                return False, f"warming up: up {uptime:.1f}s of readiness delay {self._warmup:.3f}s"
+            return True, "ok"
+
+
+readiness = _Readiness()
+
+
+class _HealthFlip:
+    """Liveness that fails after UNHEALTHY_AFTER_REQUESTS /config requests.
+
+    With UNHEALTHY_DURATION_SECONDS set it recovers after that cooldown and the count
+    starts over, cycling between healthy and unhealthy; otherwise it stays unhealthy.
+    """
+
+    def __init__(self, clock=time.monotonic) -> None:
+        self._lock = threading.Lock()
+        self._clock = clock
+        self._served = 0
+        self._unhealthy_since: Optional[float] = None
+        self._degraded_reason: Optional[str] = None
+
+    def _maybe_recover(self, now: float) -> None:
+        duration = _env_float("UNHEALTHY_DURATION_SECONDS")
+        if self._unhealthy_since is not None and duration > 0 and now - self._unhealthy_since >= duration:
+            self._unhealthy_since = None
+            self._served = 0
+            log_event("INFO", "health recovered after cooldown", cooldown_seconds=duration)
+
+    def record_config_served(self) -> None:
+        threshold = _env_int("UNHEALTHY_AFTER_REQUESTS")
+        if threshold <= 0:
+            return
+        with self._lock:
+            now = self._clock()
+            self._maybe_recover(now)
+            if self._unhealthy_since is not None:
+                return
+            self._served += 1
+            if self._served >= threshold:
+                self._unhealthy_since = now
+                log_event("WARN", "health flipped to unhealthy", served=self._served)
+
+    def degrade(self, reason: str) -> None:
+        """Fail liveness until restart; unlike the request-count flip this never recovers."""
+        with self._lock:
+            self._degraded_reason = reason
+
+    def degraded_reason(self) -> Optional[str]:
+        with self._lock:
+            return self._degraded_reason
+
+    def healthy(self) -> bool:
+        with self._lock:
+            self._maybe_recover(self._clock())
+            return self._unhealthy_since is None and self._degraded_reason is None
+
+
+health_flip = _HealthFlip()
+
+
+def degrade_health(reason: str, **fields) -> None:
+    """CRASH_MODE=degrade counterpart of terminating: flip /healthz to 503 and keep serving."""
+    health_flip.degrade(reason)
+    log_event("WARN", f"{reason}, degrading health instead of exiting", crash_mode="degrade", **fields)
+
+
+class _DependencyCheck:
+    """Health of the upstream at DEPENDENCY_URL, cached for DEPENDENCY_CHECK_INTERVAL_SECONDS.
+
+    With CB_FAILURE_THRESHOLD set, that many consecutive failures open a circuit breaker:
+    the dependency is not called and readiness fails fast for CB_OPEN_SECONDS (default 30),
+    after which one half-open probe either closes the circuit or opens it again.
+    """
+
+    TIMEOUT_SECONDS = 2.0
+
+    def __init__(self, clock=time.monotonic) -> None:
+        self._lock = threading.Lock()
+        self._clock = clock
+        self._result: Optional[tuple] = None
+        self._checked_at = 0.0
+        self._breaker = "closed"
+        self._failures = 0
+        self._opened_at = 0.0
+
+    def _probe(self, url: str) -> tuple:
+        try:
+            resp = requests.get(url, timeout=self.TIMEOUT_SECONDS)
+        except requests.RequestException as e:
+            return False, f"dependency unreachable: {e}"
+        if resp.status_code >= 500:
+            return False, f"dependency returned {resp.status_code}"
+        return True, "ok"
+
+    def _record_outcome(self, healthy: bool, now: float, url: str) -> None:
+        threshold = _env_int("CB_FAILURE_THRESHOLD")
+        if healthy:
+            if self._breaker != "closed":
+                log_event("INFO", "dependency circuit closed", dependency_url=url)
+            self._breaker = "closed"
+            self._failures = 0
+            return
+        self._failures += 1
+        if threshold > 0 and (self._breaker == "half-open" or self._failures >= threshold):
+            self._breaker = "open"
+            self._opened_at = now
+            log_event("WARN", "dependency circuit opened", dependency_url=url, consecutive_failures=self._failures)
+
+    def last_result(self) -> Optional[tuple]:
+        """The cached (healthy, reason) from the last probe, without probing; None before the first."""
+        with self._lock:
+            return self._result
+
+    def breaker_state(self) -> Optional[str]:
+        """closed, open or half-open; None when no breaker is configured."""
+        if not os.getenv("DEPENDENCY_URL") or _env_int("CB_FAILURE_THRESHOLD") <= 0:
+            return None
+        with self._lock:
+            return self._breaker
+
+    def check(self) -> tuple:
+        """Return (healthy, reason); always healthy when DEPENDENCY_URL is unset."""
+        url = os.getenv("DEPENDENCY_URL")
+        if not url:
+            return True, "ok"
+        interval = _env_float("DEPENDENCY_CHECK_INTERVAL_SECONDS", 5.0)
+        with self._lock:
+            now = self._clock()
+            if self._breaker == "open":
+                if now - self._opened_at < _env_float("CB_OPEN_SECONDS", 30.0):
+                    return False, f"dependency circuit open after {self._failures} consecutive failures"
+                self._breaker = "half-open"
+            if self._breaker == "half-open" or self._result is None or now - self._checked_at >= interval:
+                self._result = self._probe(url)
+                self._checked_at = now
+                if not self._result[0]:
+                    log_event("WARN", self._result[1], dependency_url=url)
+                self._record_outcome(self._result[0], now, url)
+            return self._result
+
+
+dependency = _DependencyCheck()
+
+
+class _RateLimiter:
+    """Per-client token buckets refilled at RATE_LIMIT_RPS, holding up to RATE_LIMIT_BURST tokens."""
+
+    def __init__(self, clock=time.monotonic) -> None:
+        self._lock = threading.Lock()
+        self._clock = clock
+        self._buckets: dict = {}
+
+    def allow(self, client_ip: str) -> tuple:
+        """Return (allowed, retry_after_seconds); everything is allowed when RATE_LIMIT_RPS is unset."""
+        rps = _env_float("RATE_LIMIT_RPS")
+        if rps <= 0:
+            return True, 0
+        burst = _env_int("RATE_LIMIT_BURST", max(1, math.ceil(rps))) or 1
+        with self._lock:
+            now = self._clock()
+            tokens, last = self._buckets.get(client_ip, (float(burst), now))
+            tokens = min(float(burst), tokens + (now - last) * rps)
+            if tokens >= 1:
+                self._buckets[client_ip] = (tokens - 1, now)
+                return True, 0
+            self._buckets[client_ip] = (tokens, now)
+            return False, max(1, math.ceil((1 - tokens) / rps))
+
+
+rate_limiter = _RateLimiter()
+
+
+class _ConcurrencyLimiter:
+    """Counting semaphore bounding in-flight /config requests to MAX_CONCURRENT_REQUESTS.
+
+    Waiters may sit on different event loops (one per TestClient request, or uvicorn's
+    single loop), so slots are handed over with call_soon_threadsafe rather than an
+    asyncio.Semaphore bound to one loop.
+    """
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._in_flight = 0
+        self._waiters: collections.deque = collections.deque()
+
+    def in_flight(self) -> int:
+        with self._lock:
+            return self._in_flight
+
+    def try_acquire(self, limit: int) -> bool:
+        with self._lock:
+            if self._in_flight < limit:
+                self._in_flight += 1
+                return True
+            return False
+
+    async def acquire(self, limit: int) -> None:
+        """Wait for a slot; a slot handed to a cancelled waiter is passed on."""
+        with self._lock:
+            if self._in_flight < limit:
+                self._in_flight += 1
+                return
+            loop = asyncio.get_running_loop()
+            waiter = loop.create_future()
+            self._waiters.append((loop, waiter))
+        try:
+            await waiter
+        except asyncio.CancelledError:
+            if waiter.done() and not waiter.cancelled():
+                self.release()
+            raise
+
+    def release(self) -> None:
+        with self._lock:
+            if not self._waiters:
+                self._in_flight -= 1
+                return
+            # The slot stays counted and moves straight to the next waiter
+            loop, waiter = self._waiters.popleft()
+        loop.call_soon_threadsafe(self._hand_over, waiter)
+
+    def _hand_over(self, waiter: asyncio.Future) -> None:
+        if waiter.cancelled():
+            self.release()
+        else:
+            waiter.set_result(None)
+
+
+concurrency = _ConcurrencyLimiter()
+
+
+def client_ip(request: Request) -> str:
+    forwarded = request.headers.get("x-forwarded-for")
+    if forwarded:
+        return forwarded.split(",")[0].strip()
+    return request.client.host if request.client else "unknown"
+
+
+def yaml_error(content: str) -> Optional[str]:
+    """Return the YAML parse error for content, or None if it parses."""
+    try:
+        list(yaml.safe_load_all(content))
+    except yaml.YAMLError as e:
+        return str(e)
+    return None
+
+
+def config_files(path: str) -> list:
+    """The files making up the config: the path itself, or a directory's *.yaml files in sorted order."""
+    if os.path.isdir(path):
+        return sorted(glob.glob(os.path.join(path, "*.yaml")))
+    return [path]
+
+
+class ConfigTooLarge(Exception):
+    """The config exceeds MAX_CONFIG_BYTES."""
+
+
+def is_config_url(path: str) -> bool:
+    return path.startswith(("http://", "https://"))
+
+
+def _decode_config(raw: bytes) -> str:
+    # Decode as text mode would, including universal newlines
+    return io.TextIOWrapper(io.BytesIO(raw), encoding="utf-8").read()
+
+
+def _read_config_files(path: str, limit: int) -> str:
+    fragments = []
+    remaining = limit
+    for file_path in config_files(path):
+        with open(file_path, "rb") as f:
+            # One byte past the budget is enough to tell the file is oversized
+            fragment = f.read(remaining + 1)
+        if len(fragment) > remaining:
+            raise ConfigTooLarge(f"config exceeds MAX_CONFIG_BYTES={limit}")
+        remaining -= len(fragment)
+        fragment = _decode_config(fragment)
+        if fragments and not fragments[-1].endswith("\n"):
+            fragments.append("\n")
+        fragments.append(fragment)
+    return "".join(fragments)
+
+
+def fetch_config(url: str, limit: int) -> str:
+    """GET the config from url within CONFIG_FETCH_TIMEOUT_SECONDS (default 5)."""
+    timeout = _env_float("CONFIG_FETCH_TIMEOUT_SECONDS", 5.0)
+    with requests.get(url, timeout=timeout, stream=True) as resp:
+        resp.raise_for_status()
+        body = bytearray()
+        for chunk in resp.iter_content(chunk_size=64 * 1024):
+            body += chunk
+            if len(body) > limit:
+                raise ConfigTooLarge(f"config exceeds MAX_CONFIG_BYTES={limit}")
+    return _decode_config(bytes(body))
+
+
+class _ConfigCache:
+    """Last config read from disk, reused for CONFIG_CACHE_TTL_MS while the files are unchanged.
+
+    An entry is dropped once the TTL expires or any file's mtime, size or inode changes;
+    with the TTL unset every call goes to reader.
+    """
+
+    def __init__(self, reader=None, clock=time.monotonic) -> None:
+        self._lock = threading.Lock()
+        self._reader = reader or _read_config_files
+        self._clock = clock
+        self._entry: Optional[tuple] = None  # (path, limit, signature, read_at, content)
+
+    @staticmethod
+    def _signature(path: str) -> tuple:
+        signature = []
+        for file_path in config_files(path):
+            st = os.stat(file_path)
+            signature.append((file_path, st.st_ino, st.st_size, st.st_mtime_ns))
+        return tuple(signature)
+
+    def read(self, path: str, limit: int) -> str:
+        ttl_ms = _env_int("CONFIG_CACHE_TTL_MS")
+        if ttl_ms <= 0:
+            return self._reader(path, limit)
+        signature = self._signature(path)
+        now = self._clock()
+        with self._lock:
+            entry = self._entry
+        if entry is not None and entry[:3] == (path, limit, signature) and now - entry[3] < ttl_ms / 1000.0:
+            return entry[4]
+        content = self._reader(path, limit)
+        with self._lock:
+            self._entry = (path, limit, signature, now, content)
+        return content
+
+
+config_cache = _ConfigCache()
+
+
 def read_config() -> str:
+    """Read the config from disk or, when CONFIG_PATH is an http(s) URL, fetch it.
+
+    Refuses more than MAX_CONFIG_BYTES (default 1 MiB) in total either way. Files are
+    served from config_cache when CONFIG_CACHE_TTL_MS is set.
+    """
     path = get_config_path()
-    with open(path, "r", encoding="utf-8") as f:
-        return f.read()
+    limit = _env_int("MAX_CONFIG_BYTES", 1024 * 1024)
+    try:
+        if is_config_url(path):
+            data = fetch_config(path, limit)
+        else:
+            data = config_cache.read(path, limit)
+    except Exception:
+        readiness.record_config_read(False)
+        raise
+    readiness.record_config_read(True)
+    return data
+
+
+_TEMPLATE_ACTION = re.compile(r"\{\{\s*\.(\w+)\s*\}\}")
+
+
+def render_response_template(template: str) -> str:
+    """Render RESPONSE_TEMPLATE, substituting {{.Now}}, {{.RequestCount}} and {{.Hostname}}.
+
+    Uses the Go text/template action syntax so templates are shared with the Go example;
+    raises ValueError for unknown fields or malformed actions.
+    """
+    fields = {
+        "Now": datetime.now(timezone.utc).isoformat(timespec="seconds").replace("+00:00", "Z"),
+        # The current request is counted once it completes, so include it here
+        "RequestCount": metrics.requests_for("/config") + 1,
+        "Hostname": socket.gethostname(),
+    }
+    leftover = _TEMPLATE_ACTION.sub("", template)
+    if "{{" in leftover or "}}" in leftover:
+        raise ValueError("template: malformed action, expected {{.Field}}")
+
+    def _field(match: re.Match) -> str:
+        name = match.group(1)
+        if name not in fields:
+            raise ValueError(f"template: can't evaluate field {name}")
+        return str(fields[name])
+
+    return _TEMPLATE_ACTION.sub(_field, template)
 
 
 def emit_error_span(config_data: str) -> None:
@@ -68,7 +700,191 @@ def emit_error_span(config_data: str) -> None:
 #        print(f"DEBUG: Created root config_error span with trace_id={span.get_span_context().trace_id:032x}", file=sys.stderr)
     except Exception as otel_e:
         # Best-effort: tracing not fatal to request handling
-        print(f"WARN: failed to record trace span for config error: {otel_e}", file=sys.stderr)
+        log_event("WARN", f"failed to record trace span for config error: {otel_e}")
+
+
+DEFAULT_LATENCY_BUCKETS = (0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0)
+
+
+def get_latency_buckets() -> tuple:
+    """Histogram upper bounds in seconds, from comma-separated LATENCY_BUCKETS."""
+    raw = os.getenv("LATENCY_BUCKETS", "")
+    if not raw.strip():
+        return DEFAULT_LATENCY_BUCKETS
+    try:
+        buckets = sorted({float(b) for b in raw.split(",") if b.strip()})
+    except ValueError:
+        buckets = []
+    if not buckets or buckets[0] <= 0 or any(math.isinf(b) or math.isnan(b) for b in buckets):
+        log_event("WARN", f"invalid LATENCY_BUCKETS={raw!r}, using defaults")
+        return DEFAULT_LATENCY_BUCKETS
+    return tuple(buckets)
+
+
+class _Metrics:
+    """Request counters exposed on /metrics, shared across handler threads."""
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._requests_by_path: dict = {}
+        self._responses_by_code: dict = {}
+        self._crash_armed = False
+        self._buckets = get_latency_buckets()
+        self._bucket_counts = [0] * len(self._buckets)
+        self._duration_sum = 0.0
+        self._duration_count = 0
+
+    def record(self, path: str, status_code: int) -> None:
+        with self._lock:
+            self._requests_by_path[path] = self._requests_by_path.get(path, 0) + 1
+            code = str(status_code)
+            self._responses_by_code[code] = self._responses_by_code.get(code, 0) + 1
+
+    def set_crash_armed(self, armed: bool) -> None:
+        with self._lock:
+            self._crash_armed = armed
+
+    def observe_duration(self, seconds: float) -> None:
+        """Add one /config request duration to the latency histogram."""
+        with self._lock:
+            for i, bound in enumerate(self._buckets):
+                if seconds <= bound:
+                    self._bucket_counts[i] += 1
+                    break
+            self._duration_sum += seconds
+            self._duration_count += 1
+
+    def requests_for(self, path: str) -> int:
+        with self._lock:
+            return self._requests_by_path.get(path, 0)
+
+    def reset(self) -> None:
+        """Zero the counters and the latency histogram; the crash-armed gauge reflects state and is kept."""
+        with self._lock:
+            self._requests_by_path.clear()
+            self._responses_by_code.clear()
+            self._bucket_counts = [0] * len(self._buckets)
+            self._duration_sum = 0.0
+            self._duration_count = 0
+
+    def render(self, openmetrics: bool = False) -> str:
+        """Render the metrics in Prometheus text format, or OpenMetrics when openmetrics is set.
+
+        OpenMetrics names counter families without the _total suffix and ends with # EOF.
+        """
+        with self._lock:
+            by_path = sorted(self._requests_by_path.items())
+            by_code = sorted(self._responses_by_code.items())
+            crash_armed = self._crash_armed
+            bucket_counts = list(self._bucket_counts)
+            duration_sum = self._duration_sum
+            duration_count = self._duration_count
+
+        common = metric_labels()
+
+        def family(name: str, kind: str, help_text: str) -> list:
+            meta = name[: -len("_total")] if openmetrics and kind == "counter" else name
+            return [f"# HELP {meta} {help_text}", f"# TYPE {meta} {kind}"]
+
+        def sample(name: str, value, **labels) -> str:
+            pairs = ",".join(f'{key}="{_escape_label_value(str(v))}"' for key, v in {**labels, **common}.items())
+            return f"{name}{{{pairs}}} {value}" if pairs else f"{name} {value}"
+
+        lines = family("alert_example_requests_total", "counter", "Total HTTP requests received, by path.")
+        for path, count in by_path:
+            lines.append(sample("alert_example_requests_total", count, path=path))
+        lines += family("alert_example_responses_total", "counter", "Total HTTP responses sent, by status code.")
+        for code, count in by_code:
+            lines.append(sample("alert_example_responses_total", count, code=code))
+        lines += family("alert_example_crash_armed", "gauge", "Whether the loaded config contains the crash keyword.")
+        lines.append(sample("alert_example_crash_armed", 1 if crash_armed else 0))
+        lines += family("alert_example_threads", "gauge", "Number of live Python threads.")
+        lines.append(sample("alert_example_threads", threading.active_count()))
+        lines += family("alert_example_active_connections", "gauge", "Number of open HTTP connections.")
+        lines.append(sample("alert_example_active_connections", active_connections.value()))
+        rss = resident_memory_bytes()
+        if rss is not None:
+            lines += family("alert_example_resident_memory_bytes", "gauge", "Resident memory size in bytes.")
+            lines.append(sample("alert_example_resident_memory_bytes", rss))
+        lines += family(
+            "alert_example_request_duration_seconds", "histogram", "Time taken to serve /config requests."
+        )
+        cumulative = 0
+        for bound, count in zip(self._buckets, bucket_counts):
+            cumulative += count
+            lines.append(sample("alert_example_request_duration_seconds_bucket", cumulative, le=repr(bound)))
+        lines += [
+            sample("alert_example_request_duration_seconds_bucket", duration_count, le="+Inf"),
+            sample("alert_example_request_duration_seconds_sum", repr(duration_sum)),
+            sample("alert_example_request_duration_seconds_count", duration_count),
+        ]
+        if openmetrics:
+            lines.append("# EOF")
+        return "\n".join(lines) + "\n"
+
+
+class _ConnectionGauge:
+    """Open HTTP connections across all uvicorn servers, like Go's http.Server.ConnState."""
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._active = 0
+
+    def opened(self) -> None:
+        with self._lock:
+            self._active += 1
+
+    def closed(self) -> None:
+        with self._lock:
+            self._active -= 1
+
+    def value(self) -> int:
+        with self._lock:
+            return self._active
+
+
+active_connections = _ConnectionGauge()
+
+
+def resident_memory_bytes() -> Optional[int]:
+    """Resident set size from /proc/self/statm, or None where procfs is unavailable."""
+    try:
+        with open("/proc/self/statm") as f:
+            resident_pages = int(f.read().split()[1])
+    except (OSError, ValueError, IndexError):
+        return None
+    return resident_pages * os.sysconf("SC_PAGE_SIZE")
+
+
+def _escape_label_value(value: str) -> str:
+    return value.replace("\\", "\\\\").replace('"', '\\"').replace("\n", "\\n")
+
+
+_LABEL_NAME = re.compile(r"[a-zA-Z_][a-zA-Z0-9_]*")
+
+# Per-series labels that a common label must not shadow
+SERIES_LABELS = {"path", "code", "le"}
+
+
+def metric_labels() -> dict:
+    """Labels added to every /metrics series: scenario, instance (HOSTNAME) and pod (POD_NAME).
+
+    METRIC_LABELS ("key=value,...") adds more and overrides the defaults; empty values are dropped.
+    """
+    labels = {"scenario": get_scenario(), "instance": os.getenv("HOSTNAME", ""), "pod": os.getenv("POD_NAME", "")}
+    for item in os.getenv("METRIC_LABELS", "").split(","):
+        if not item.strip():
+            continue
+        key, sep, value = item.partition("=")
+        key = key.strip()
+        if not sep or not _LABEL_NAME.fullmatch(key) or key.startswith("__") or key in SERIES_LABELS:
+            log_event("WARN", f"ignoring invalid METRIC_LABELS entry {item.strip()!r}")
+            continue
+        labels[key] = value.strip()
+    return {key: value for key, value in labels.items() if value}
+
+
+metrics = _Metrics()
 
 
 app = FastAPI()
@@ -89,7 +905,12 @@ def _setup_otel_tracing() -> None:
 
         service_name = os.getenv("OTEL_SERVICE_NAME", "alert-example")
         service_version = os.getenv("OTEL_SERVICE_VERSION", "0.1.0")
-        endpoint = os.getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://otel-collector-collector.observability-hub.svc.cluster.local:4318")
+        endpoint = os.getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+        if not endpoint:
+            # Keep the default no-op provider: spans are created but never exported
+            _otel_initialized = True
+            log_event("INFO", "OTEL_EXPORTER_OTLP_ENDPOINT not set, tracing disabled")
+            return
 
         # Check if tracer provider is already configured
         current_provider = trace.get_tracer_provider()
@@ -122,61 +943,3007 @@ def _setup_otel_tracing() -> None:
                 pass  # Best-effort; ignore if already instrumented
             
         _otel_initialized = True
-        print(f"INFO: OpenTelemetry tracing initialized, endpoint={endpoint}, service={service_name}")
+        log_event("INFO", "OpenTelemetry tracing initialized", endpoint=endpoint, service=service_name)
     except Exception as e:
-        print(f"WARN: failed to initialize OpenTelemetry tracing: {e}", file=sys.stderr)
+        log_event("WARN", f"failed to initialize OpenTelemetry tracing: {e}")
 
 
 @app.on_event("startup")
-def startup_check() -> None:
+def setup_tracing() -> None:
     _setup_otel_tracing()
+
+
+def startup_check() -> None:
     try:
+        if _env_flag("FAIL_STARTUP_READ"):
+            # Only this read fails; the file and later /config reads are untouched
+            readiness.record_config_read(False)
+            raise OSError("simulated failure (FAIL_STARTUP_READ)")
         data = read_config()
-        if "Crash" in data:
-            print("ERROR: config contained Crash keyword on startup, terminating", file=sys.stderr)
+        crash = should_crash(data)
+        metrics.set_crash_armed(crash)
+        if crash and get_crash_mode() == "degrade":
+            degrade_health("config contained crash keyword on startup", config_path=get_config_path())
+        elif crash:
+            exit_code = get_crash_exit_code()
+            log_event(
+                "ERROR",
+                "config contained crash keyword on startup, terminating",
+                config_path=get_config_path(),
+                exit_code=exit_code,
+            )
             # Exit non-zero like the Go example
-            sys.exit(1)
+            exit_func(exit_code)
     except Exception as e:
-        print(f"WARN: could not read config on startup: {e}", file=sys.stderr)
+        if not _env_flag("REQUIRE_CONFIG", default=True):
+            log_event(
+                "WARN",
+                f"could not read config on startup, serving not-ready until it appears: {e}",
+                config_path=get_config_path(),
+            )
+            return
+        log_event("WARN", f"could not read config on startup: {e}", config_path=get_config_path())
         # Mirror Go example behavior: exit if startup read fails
-        sys.exit(1)
+        exit_func(1)
 
 
-@app.get("/healthz")
-def healthz() -> Response:
-    return Response(content="ok", media_type="text/plain; charset=utf-8")
+@app.middleware("http")
+async def recover_panics(request: Request, call_next):
+    try:
+        return await call_next(request)
+    except Exception:
+        stack = traceback.format_exc()
+        if not _env_flag("RECOVER_PANICS", default=True):
+            log_event("ERROR", "unrecovered panic, terminating", path=request.url.path, stack=stack)
+            # Go's exit status for an unrecovered panic
+            exit_func(2)
+            return error_response(request, 500, "internal_error", "internal server error")
+        log_event("ERROR", "recovered panic", path=request.url.path, status=500, stack=stack)
+        return error_response(request, 500, "internal_error", "internal server error")
 
 
-@app.get("/config")
-def get_config() -> Response:
+# Resolves to the real provider once _setup_otel_tracing installs one; a no-op until then
+request_tracer = trace.get_tracer("alert-example")
+
+TRACED_PATHS = {"/config", "/healthz"}
+
+
+@app.middleware("http")
+async def trace_requests(request: Request, call_next):
+    if request.url.path not in TRACED_PATHS:
+        return await call_next(request)
+    with request_tracer.start_as_current_span("alert_example.request") as span:
+        span.set_attribute("url.path", request.url.path)
+        response = await call_next(request)
+        span.set_attribute("http.response.status_code", response.status_code)
+        span.set_attribute("alert_example.crash_keyword_matched", getattr(request.state, "crash_matched", False))
+        if response.status_code >= 500:
+            span.set_status(Status(StatusCode.ERROR))
+        return response
+
+
+# path label for requests that match no route, so scanners can't mint new series
+UNMATCHED_PATH = "other"
+
+
+def route_label(scope: dict) -> str:
+    """The path template of the route serving scope (e.g. /config/{name:path}), else UNMATCHED_PATH.
+
+    A route matching the path but not the method still names it, as the request hit a real path.
+    """
+    label = UNMATCHED_PATH
+    for route in app.router.routes:
+        match, _ = route.matches(scope)
+        if match == Match.FULL:
+            return route.path
+        if match == Match.PARTIAL and label == UNMATCHED_PATH:
+            label = route.path
+    return label
+
+
+@app.middleware("http")
+async def count_requests(request: Request, call_next):
+    start = time.perf_counter()
+    response = await call_next(request)
+    if request.url.path == "/config":
+        metrics.observe_duration(time.perf_counter() - start)
+    metrics.record(route_label(request.scope), response.status_code)
+    return response
+
+
+# Methods each guarded route accepts; anything else is a 405 that never reaches the metrics
+ALLOWED_METHODS = {
+    "/config": ("GET", "HEAD"),
+    # Listed so other methods get a 405 instead of falling through to /config/{name:path} as a 404
+    "/config/reload": ("POST",),
+    "/config/validate": ("POST",),
+    "/config/diff": ("POST",),
+    "/healthz": ("GET", "HEAD"),
+    "/admin/drain": ("POST",),
+    "/admin/undrain": ("POST",),
+    "/admin/ready": ("POST",),
+    "/admin/shutdown": ("POST",),
+    "/admin/crash": ("POST",),
+    "/admin/config": ("POST",),
+}
+
+
+class MethodGuardMiddleware:
+    """Enforces ALLOWED_METHODS, answering 405 with an Allow header for any other method.
+
+    HEAD is served by the GET handler with the body dropped, keeping its headers.
+    """
+
+    def __init__(self, app) -> None:
+        self.app = app
+
+    async def __call__(self, scope, receive, send) -> None:
+        allowed = ALLOWED_METHODS.get(scope["path"]) if scope["type"] == "http" else None
+        if allowed is None:
+            await self.app(scope, receive, send)
+            return
+        method = scope["method"]
+        if method not in allowed:
+            log_event("WARN", "method not allowed", path=scope["path"], method=method, status=405)
+            response = error_response(
+                Request(scope), 405, "method_not_allowed", "method not allowed", headers={"Allow": ", ".join(allowed)}
+            )
+            await response(scope, receive, send)
+            return
+        if method != "HEAD":
+            await self.app(scope, receive, send)
+            return
+
+        async def headers_only_send(message) -> None:
+            if message["type"] == "http.response.body":
+                message = {**message, "body": b""}
+            await send(message)
+
+        await self.app({**scope, "method": "GET"}, receive, headers_only_send)
+
+
+app.add_middleware(MethodGuardMiddleware)
+
+
+@app.middleware("http")
+async def propagate_request_id(request: Request, call_next):
+    request_id = request.headers.get("x-request-id", "").strip() or str(uuid.uuid4())
+    token = _request_id.set(request_id)
     try:
-        data = read_config()
-    except Exception as e:
-        print(f"ERROR: failed to read config file {get_config_path()}: {e}", file=sys.stderr)
-        return Response(content="failed to read config", status_code=500)
+        response = await call_next(request)
+    finally:
+        _request_id.reset(token)
+    response.headers["X-Request-ID"] = request_id
+    return response
 
-    # Emit a root-level trace span when config contains "Error"
-    if "Error" in data:
-        emit_error_span(data)
-        # Exit non-zero shortly after returning, to allow HTTP response and span export
-        def _exit_after_flush() -> None:
-            try:
-                time.sleep(5.0)
-            finally:
-                os._exit(1)
-        threading.Thread(target=_exit_after_flush, daemon=True).start()
-        return Response(content="config triggered error; exiting", status_code=500)
 
-    if "Crash" in data:
-        print("ERROR: config contained Crash keyword, terminating", file=sys.stderr)
+def get_cors_origins() -> list:
+    raw = os.getenv("CORS_ALLOW_ORIGINS", "")
+    return [origin.strip() for origin in raw.split(",") if origin.strip()]
 
-        def _delayed_exit() -> None:
-            time.sleep(0.5)
-            os._exit(1)
 
-        threading.Thread(target=_delayed_exit, daemon=True).start()
-        return Response(content="config triggered crash", status_code=500)
+def cors_allow_origin(origin: str) -> Optional[str]:
+    """The Access-Control-Allow-Origin value for origin, or None when it is not allowed."""
+    allowed = get_cors_origins()
+    if "*" in allowed:
+        return "*"
+    return origin if origin in allowed else None
 
-    return Response(content=data, media_type="text/plain; charset=utf-8")
+
+@app.middleware("http")
+async def apply_cors(request: Request, call_next):
+    origin = request.headers.get("origin")
+    if not origin or not get_cors_origins():
+        return await call_next(request)
+    allow_origin = cors_allow_origin(origin)
+    preflight = request.method == "OPTIONS" and "access-control-request-method" in request.headers
+    if preflight:
+        if allow_origin is None:
+            log_event("WARN", "CORS preflight from disallowed origin", path=request.url.path, origin=origin, status=403)
+            return Response(content="origin not allowed", status_code=403)
+        response = Response(status_code=204)
+        response.headers["Access-Control-Allow-Methods"] = "GET, POST, OPTIONS"
+        requested_headers = request.headers.get("access-control-request-headers")
+        if requested_headers:
+            response.headers["Access-Control-Allow-Headers"] = requested_headers
+        response.headers["Access-Control-Max-Age"] = "600"
+    else:
+        response = await call_next(request)
+        if allow_origin is None:
+            return response
+    response.headers["Access-Control-Allow-Origin"] = allow_origin
+    if allow_origin != "*":
+        response.headers.add_vary_header("Origin")
+    return response
+
+
+def format_access_log(scope: dict, status: int, size: int, duration: float) -> str:
+    """Apache combined log format, with the request duration in seconds appended."""
+    headers = {k.decode("latin-1").lower(): v.decode("latin-1") for k, v in scope.get("headers", [])}
+
+    def quoted(value: str) -> str:
+        return '"' + (value or "-").replace("\\", "\\\\").replace('"', '\\"') + '"'
+
+    client = scope.get("client")
+    target = scope.get("path", "")
+    query = scope.get("query_string", b"").decode("latin-1")
+    if query:
+        target += "?" + query
+    request_line = f'{scope.get("method", "-")} {target} HTTP/{scope.get("http_version", "1.1")}'
+    timestamp = datetime.now().astimezone().strftime("%d/%b/%Y:%H:%M:%S %z")
+    return (
+        f"{client[0] if client else '-'} - - [{timestamp}] {quoted(request_line)} {status} {size or '-'} "
+        f"{quoted(headers.get('referer'))} {quoted(headers.get('user-agent'))} {duration:.6f}"
+    )
+
+
+class AccessLogMiddleware:
+    """Writes one combined-format access log line per request to stdout when ACCESS_LOG=true.
+
+    Wraps send() to capture the final status and the number of body bytes written.
+    """
+
+    def __init__(self, app) -> None:
+        self.app = app
+
+    async def __call__(self, scope, receive, send) -> None:
+        if scope["type"] != "http" or not _env_flag("ACCESS_LOG"):
+            await self.app(scope, receive, send)
+            return
+        started = time.monotonic()
+        status, size = 500, 0
+
+        async def capturing_send(message) -> None:
+            nonlocal status, size
+            if message["type"] == "http.response.start":
+                status = message["status"]
+            elif message["type"] == "http.response.body":
+                size += len(message.get("body", b""))
+            await send(message)
+
+        try:
+            await self.app(scope, receive, capturing_send)
+        finally:
+            print(format_access_log(scope, status, size, time.monotonic() - started), flush=True)
+
+
+app.add_middleware(AccessLogMiddleware)
+
+
+BODY_LOG_PATHS = {"/config", "/echo"}
+
+
+def get_log_redactions() -> list:
+    """Substrings masked in logged bodies, from comma-separated LOG_REDACT."""
+    return [part.strip() for part in os.getenv("LOG_REDACT", "").split(",") if part.strip()]
+
+
+def loggable_body(data: bytes, limit: int, redactions: list) -> tuple:
+    """Return (text, truncated): data redacted, cut to limit bytes and escaped onto one printable line.
+
+    Redaction runs before truncation so a secret straddling the cut is not half-logged;
+    bytes that aren't UTF-8 and control characters are escaped rather than written raw.
+    """
+    for secret in redactions:
+        data = data.replace(secret.encode("utf-8"), b"***")
+    truncated = len(data) > limit
+    text = data[:limit].decode("utf-8", errors="backslashreplace")
+    return "".join(c if c.isprintable() else c.encode("unicode_escape").decode("ascii") for c in text), truncated
+
+
+class _BodyCapture:
+    """Keeps the first limit bytes of a body plus enough extra to redact a secret crossing the limit."""
+
+    def __init__(self, limit: int, redactions: list) -> None:
+        self.limit = limit
+        self.redactions = redactions
+        self._keep = limit + max((len(r.encode("utf-8")) for r in redactions), default=0)
+        self._buffer = bytearray()
+        self.size = 0
+
+    def write(self, chunk: bytes) -> None:
+        self.size += len(chunk)
+        if len(self._buffer) < self._keep:
+            self._buffer += chunk[: self._keep - len(self._buffer)]
+
+    def log(self, msg: str, **fields) -> None:
+        body, truncated = loggable_body(bytes(self._buffer), self.limit, self.redactions)
+        # Bytes past the kept buffer were never seen, so the body is truncated even if redaction shrank it
+        truncated = truncated or self.size > len(self._buffer)
+        log_event("INFO", msg, bytes=self.size, truncated=truncated, body=body, **fields)
+
+
+class BodyLogMiddleware:
+    """Logs /config and /echo request bodies (LOG_REQUEST_BODY) and response bodies (LOG_RESPONSE_BODY).
+
+    Bodies are capped at LOG_BODY_MAX_BYTES (default 1024) and masked with LOG_REDACT.
+    Works like a buffering ResponseWriter: receive() and send() are wrapped and the
+    captured bytes logged once the response is complete. Being outside propagate_request_id,
+    it tags the lines with the X-Request-ID the response went out with.
+    """
+
+    def __init__(self, app) -> None:
+        self.app = app
+
+    async def __call__(self, scope, receive, send) -> None:
+        log_request = _env_flag("LOG_REQUEST_BODY")
+        log_response = _env_flag("LOG_RESPONSE_BODY")
+        if scope["type"] != "http" or scope["path"] not in BODY_LOG_PATHS or not (log_request or log_response):
+            await self.app(scope, receive, send)
+            return
+        limit = _env_int("LOG_BODY_MAX_BYTES", 1024)
+        redactions = get_log_redactions()
+        request_body = _BodyCapture(limit, redactions)
+        response_body = _BodyCapture(limit, redactions)
+        status, encoding, request_id = 500, None, None
+
+        async def capturing_receive():
+            message = await receive()
+            if message["type"] == "http.request":
+                request_body.write(message.get("body", b""))
+            return message
+
+        async def capturing_send(message) -> None:
+            nonlocal status, encoding, request_id
+            if message["type"] == "http.response.start":
+                status = message["status"]
+                for name, value in message.get("headers", []):
+                    if name.lower() == b"content-encoding":
+                        encoding = value.decode("latin-1")
+                    elif name.lower() == b"x-request-id":
+                        request_id = value.decode("latin-1")
+            elif message["type"] == "http.response.body":
+                response_body.write(message.get("body", b""))
+            await send(message)
+
+        try:
+            await self.app(scope, capturing_receive if log_request else receive, capturing_send)
+        finally:
+            ids = {"request_id": request_id} if request_id else {}
+            if log_request:
+                request_body.log("request body", path=scope["path"], method=scope["method"], **ids)
+            if log_response:
+                fields = {"content_encoding": encoding} if encoding else {}
+                response_body.log("response body", path=scope["path"], status=status, **fields, **ids)
+
+
+app.add_middleware(BodyLogMiddleware)
+
+
+OPENMETRICS_CONTENT_TYPE = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+
+@app.get("/metrics")
+def handle_metrics(request: Request) -> Response:
+    if "application/openmetrics-text" in request.headers.get("accept", ""):
+        return Response(content=metrics.render(openmetrics=True), media_type=OPENMETRICS_CONTENT_TYPE)
+    return Response(content=metrics.render(), media_type="text/plain; version=0.0.4; charset=utf-8")
+
+
+@app.post("/metrics/reset")
+def handle_metrics_reset() -> Response:
+    if not _env_flag("ALLOW_METRICS_RESET"):
+        return Response(content="metrics reset is disabled", status_code=404)
+    metrics.reset()
+    log_event("INFO", "metrics reset", path="/metrics/reset")
+    return Response(status_code=204)
+
+
+@app.get("/leak/status")
+def handle_leak_status() -> Response:
+    return JSONResponse({
+        "running": memory_leak.running(),
+        "allocated_mb": round(memory_leak.allocated_mb(), 3),
+    })
+
+
+@app.post("/leak/stop")
+def handle_leak_stop() -> Response:
+    memory_leak.stop()
+    log_event("INFO", "memory leak stopped", allocated_mb=round(memory_leak.allocated_mb(), 3))
+    return handle_leak_status()
+
+
+def _burn_cpu(deadline: float, stop) -> None:
+    x = 0
+    while time.monotonic() < deadline and not stop.is_set():
+        for _ in range(10000):
+            x = (x * 31 + 7) % 1000003
+
+
+@app.get("/burn")
+async def handle_burn(request: Request) -> Response:
+    try:
+        duration_ms = int(request.query_params.get("duration_ms", "1000"))
+        workers = int(request.query_params.get("workers", "1"))
+    except ValueError:
+        return error_response(request, 400, "invalid_burn", "duration_ms and workers must be integers")
+    if duration_ms < 0 or workers < 1:
+        return error_response(request, 400, "invalid_burn", "duration_ms must be >= 0 and workers >= 1")
+
+    # Processes rather than threads: the GIL would cap threads at a single core
+    ctx = multiprocessing.get_context("fork")
+    stop = ctx.Event()
+    started = time.monotonic()
+    deadline = started + duration_ms / 1000.0
+    procs = [ctx.Process(target=_burn_cpu, args=(deadline, stop), daemon=True) for _ in range(workers)]
+    for proc in procs:
+        proc.start()
+    completed = await _sleep_unless_disconnected(request, duration_ms / 1000.0)
+    stop.set()
+    await asyncio.to_thread(lambda: [proc.join() for proc in procs])
+
+    elapsed_ms = int((time.monotonic() - started) * 1000)
+    if not completed:
+        log_event("INFO", "client disconnected, stopped burning CPU", path="/burn", elapsed_ms=elapsed_ms)
+        return Response(status_code=499)
+    return JSONResponse({"workers": workers, "duration_ms": duration_ms, "elapsed_ms": elapsed_ms})
+
+
+@app.get("/sleep")
+async def handle_sleep(request: Request) -> Response:
+    """Hold the request for ?ms= milliseconds (default 1000), capped at MAX_SLEEP_MS (default 60000)."""
+    try:
+        requested_ms = int(request.query_params.get("ms", "1000"))
+    except ValueError:
+        return error_response(request, 400, "invalid_sleep", "ms must be an integer")
+    if requested_ms < 0:
+        return error_response(request, 400, "invalid_sleep", "ms must be >= 0")
+    sleep_ms = min(requested_ms, _env_int("MAX_SLEEP_MS", 60000))
+
+    started = time.monotonic()
+    completed = await _sleep_unless_disconnected(request, sleep_ms / 1000.0)
+    slept_ms = int((time.monotonic() - started) * 1000)
+    if not completed:
+        log_event("INFO", "client disconnected during sleep", path="/sleep", slept_ms=slept_ms)
+        return Response(status_code=499)
+    return JSONResponse({"requested_ms": requested_ms, "slept_ms": slept_ms})
+
+
+@app.get("/slow")
+async def handle_slow(request: Request) -> Response:
+    """Trickle the config body one byte at a time, capped at MAX_SLOW_BYTES (default 1024)."""
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    try:
+        byte_delay_ms = int(request.query_params.get("byte_delay_ms", "100"))
+    except ValueError:
+        return error_response(request, 400, "invalid_delay", "byte_delay_ms must be an integer")
+    if byte_delay_ms < 0:
+        return error_response(request, 400, "invalid_delay", "byte_delay_ms must be >= 0")
+    try:
+        data = await asyncio.to_thread(read_config)
+    except Exception as e:
+        log_event("ERROR", f"failed to read config file {get_config_path()}: {e}", path="/slow", status=500)
+        return error_response(request, 500, "config_read_failed", "failed to read config")
+    body = data.encode("utf-8")[: _env_int("MAX_SLOW_BYTES", 1024)]
+
+    async def trickle():
+        for i in range(len(body)):
+            if i > 0 and not await _sleep_unless_disconnected(request, byte_delay_ms / 1000.0):
+                log_event("INFO", "client disconnected during slow response", path="/slow", bytes_sent=i)
+                return
+            # Each chunk is written to the transport as soon as it is yielded
+            yield body[i : i + 1]
+
+    return StreamingResponse(trickle(), media_type="text/plain; charset=utf-8")
+
+
+@app.get("/panic")
+def handle_panic() -> Response:
+    raise RuntimeError("injected panic via /panic")
+
+
+@app.get("/version")
+def handle_version() -> Response:
+    return JSONResponse({"version": version, "commit": commit, "buildDate": build_date})
+
+
+# Runtime profiles in the spirit of Go's net/http/pprof; only mounted with ENABLE_PPROF=true
+pprof_router = APIRouter(prefix="/debug/pprof")
+
+PPROF_PROFILES = {"heap": "top allocation sites (tracemalloc)", "threads": "stack traces of all threads"}
+
+
+@pprof_router.get("/")
+def handle_pprof_index() -> Response:
+    lines = [f"/debug/pprof/{name}: {description}" for name, description in PPROF_PROFILES.items()]
+    return Response(content="\n".join(lines) + "\n", media_type="text/plain; charset=utf-8")
+
+
+@pprof_router.get("/heap")
+def handle_pprof_heap() -> Response:
+    current, peak = tracemalloc.get_traced_memory()
+    lines = [f"# traced memory: current={current} peak={peak} bytes"]
+    for stat in tracemalloc.take_snapshot().statistics("lineno")[:50]:
+        lines.append(str(stat))
+    return Response(content="\n".join(lines) + "\n", media_type="text/plain; charset=utf-8")
+
+
+@pprof_router.get("/threads")
+def handle_pprof_threads() -> Response:
+    names = {thread.ident: thread.name for thread in threading.enumerate()}
+    sections = []
+    for ident, frame in sys._current_frames().items():
+        stack = "".join(traceback.format_stack(frame))
+        sections.append(f"thread {names.get(ident, ident)} ({ident}):\n{stack}")
+    return Response(content="\n".join(sections), media_type="text/plain; charset=utf-8")
+
+
+if _env_flag("ENABLE_PPROF"):
+    # Heap profiles need allocation tracing from as early as possible
+    tracemalloc.start()
+    app.include_router(pprof_router)
+
+
+def health_components() -> dict:
+    """Component statuses for DETAILED_HEALTH; reports cached state only, so liveness never probes."""
+    ever_loaded, last_read_ok = readiness.config_status()
+    dependency_url = os.getenv("DEPENDENCY_URL")
+    result = dependency.last_result() if dependency_url else None
+    watcher = config_watcher
+    return {
+        "config": {"loaded": ever_loaded, "last_read_ok": last_read_ok},
+        "watcher": {"enabled": watcher is not None, "running": watcher is not None and watcher.running()},
+        "dependency": {
+            "configured": bool(dependency_url),
+            "reachable": result[0] if result else None,
+            "reason": result[1] if result else None,
+        },
+    }
+
+
+@app.get("/healthz")
+def healthz() -> Response:
+    healthy = health_flip.healthy()
+    reason = None if healthy else health_flip.degraded_reason()
+    if _env_flag("DETAILED_HEALTH"):
+        body = {
+            "status": "ok" if healthy else "unhealthy",
+            "reason": reason,
+            "components": health_components(),
+            "uptime_seconds": round(time.monotonic() - process_started, 3),
+        }
+        return JSONResponse(body, status_code=200 if healthy else 503)
+    if not healthy:
+        content = f"unhealthy: {reason}" if reason else "unhealthy"
+        return Response(content=content, status_code=503, media_type="text/plain; charset=utf-8")
+    return Response(content="ok", media_type="text/plain; charset=utf-8")
+
+
+@app.post("/admin/drain")
+def handle_drain() -> Response:
+    readiness.set_draining(True)
+    log_event("INFO", "drain mode enabled", path="/admin/drain")
+    return JSONResponse({"draining": True})
+
+
+@app.post("/admin/undrain")
+def handle_undrain() -> Response:
+    readiness.set_draining(False)
+    log_event("INFO", "drain mode disabled", path="/admin/undrain")
+    return JSONResponse({"draining": False})
+
+
+READY_OVERRIDES = {"true": True, "false": False, "auto": None}
+
+
+@app.post("/admin/ready")
+def handle_ready_override(request: Request) -> Response:
+    state = request.query_params.get("state", "").lower()
+    if state not in READY_OVERRIDES:
+        return error_response(request, 400, "invalid_state", "state must be true, false or auto")
+    readiness.set_override(READY_OVERRIDES[state])
+    log_event("INFO", "readiness override set", path="/admin/ready", state=state)
+    return JSONResponse({"state": state})
+
+
+RUNTIME_FIELDS = ("error_rate", "response_delay_ms", "status_sequence", "draining")
+
+
+def parse_runtime_update(doc) -> dict:
+    """Validate a POST /admin/config document, raising ValueError on the first bad or unknown field."""
+    if not isinstance(doc, dict):
+        raise ValueError("body must be a JSON object")
+    unknown = sorted(set(doc) - set(RUNTIME_FIELDS))
+    if unknown:
+        raise ValueError(f"unknown fields: {', '.join(unknown)}")
+    for name, value in doc.items():
+        if value is None and name != "draining":
+            continue
+        number = isinstance(value, (int, float)) and not isinstance(value, bool)
+        if name == "error_rate" and not (number and 0 <= value <= 1):
+            raise ValueError("error_rate must be a number between 0 and 1")
+        if name == "response_delay_ms" and not (number and isinstance(value, int) and value >= 0):
+            raise ValueError("response_delay_ms must be a non-negative integer")
+        if name == "status_sequence" and not (
+            isinstance(value, str) and None not in [_parse_status(part.strip()) for part in value.split(",")]
+        ):
+            raise ValueError("status_sequence must be comma-separated HTTP status codes")
+        if name == "draining" and not isinstance(value, bool):
+            raise ValueError("draining must be true or false")
+    return dict(doc)
+
+
+class _RuntimeSettings:
+    """Overrides set through POST /admin/config, taking precedence over the environment.
+
+    Updates are applied under one lock, and /config reads them from a snapshot() pinned for
+    the whole request, so a request sees either none or all of them.
+    """
+
+    def __init__(self) -> None:
+        # Reentrant: the getters consulted while building the effective settings take it too
+        self._lock = threading.RLock()
+        self._values: dict = {}
+        self._pinned: contextvars.ContextVar = contextvars.ContextVar("runtime_settings", default=None)
+
+    def get(self, name: str):
+        """The override for name, or None when the environment applies."""
+        pinned = self._pinned.get()
+        if pinned is not None:
+            return pinned.get(name)
+        with self._lock:
+            return self._values.get(name)
+
+    @contextlib.contextmanager
+    def snapshot(self):
+        """Pin a copy of the current overrides, which get() then returns for the rest of the request."""
+        with self._lock:
+            token = self._pinned.set(dict(self._values))
+        try:
+            yield
+        finally:
+            self._pinned.reset(token)
+
+    def apply(self, updates: dict) -> dict:
+        """Apply validated updates together; a None value drops that override. Returns effective_runtime_settings()."""
+        with self._lock:
+            for name, value in updates.items():
+                if name == "draining":
+                    readiness.set_draining(value)
+                elif value is None:
+                    self._values.pop(name, None)
+                else:
+                    self._values[name] = value
+            return effective_runtime_settings()
+
+
+runtime_settings = _RuntimeSettings()
+
+
+def effective_runtime_settings() -> dict:
+    return {
+        "error_rate": get_error_rate(),
+        "response_delay_ms": get_response_delay_ms(),
+        "status_sequence": get_status_sequence() or None,
+        "draining": readiness.draining(),
+    }
+
+
+@app.post("/admin/config")
+async def handle_runtime_config(request: Request) -> Response:
+    """Set the error rate, response delay, status sequence and drain state in one atomic update.
+
+    Requires configured basic auth. Answers with the resulting effective settings.
+    """
+    if get_basic_auth() is None:
+        return Response(content="runtime config requires basic auth", status_code=404)
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    try:
+        doc = json.loads(await request.body())
+    except ValueError as e:
+        return error_response(request, 400, "invalid_runtime_config", f"body must be JSON: {e}")
+    try:
+        updates = parse_runtime_update(doc)
+    except ValueError as e:
+        return error_response(request, 400, "invalid_runtime_config", str(e))
+    settings = runtime_settings.apply(updates)
+    log_event("INFO", "runtime config updated", path="/admin/config", fields=",".join(sorted(updates)))
+    return JSONResponse(settings)
+
+
+def toggle_drain(signum: int) -> None:
+    """SIGUSR1 handler: each signal flips drain mode on or off."""
+    draining = readiness.toggle_draining()
+    log_event("INFO", f"received {signal.Signals(signum).name}, drain mode {'enabled' if draining else 'disabled'}")
+
+
+def ready_file_check() -> tuple:
+    """Return (ready, reason); not ready while READY_FILE is set but absent, re-checked per probe."""
+    path = os.getenv("READY_FILE")
+    if path and not os.path.exists(path):
+        return False, f"waiting for ready file {path}"
+    return True, "ok"
+
+
+@app.get("/readyz")
+def readyz() -> Response:
+    forced = readiness.override()
+    comments = []
+    if forced is not None:
+        ready, reason = forced, "forced"
+        comments.append(f"# readiness forced by /admin/ready?state={str(forced).lower()}")
+    else:
+        ready, reason = readiness.check()
+        if ready:
+            ready, reason = ready_file_check()
+        if ready:
+            ready, reason = dependency.check()
+    breaker = dependency.breaker_state()
+    if breaker is not None:
+        comments.append(f"# dependency circuit breaker: {breaker}")
+    comment = "\n" + "\n".join(comments) + "\n" if comments else ""
+    if not ready:
+        return Response(
+            content=f"not ready: {reason}{comment}", status_code=503, media_type="text/plain; charset=utf-8"
+        )
+    return Response(content=f"ok{comment}", media_type="text/plain; charset=utf-8")
+
+
+def get_response_delay_ms() -> int:
+    override = runtime_settings.get("response_delay_ms")
+    if override is not None:
+        return int(override)
+    raw = scenario_env("RESPONSE_DELAY_MS")
+    if not raw:
+        return 0
+    try:
+        return max(0, int(raw))
+    except ValueError:
+        log_event("WARN", f"invalid RESPONSE_DELAY_MS={raw!r}, not delaying")
+        return 0
+
+
+PAD_BYTE = "x"
+
+
+def get_pad_bytes(request: Request) -> Optional[int]:
+    """Filler bytes to append to /config, from ?pad_bytes= or RESPONSE_PAD_BYTES.
+
+    Capped at MAX_PAD_BYTES (default 10 MiB); None when ?pad_bytes= is not a non-negative integer.
+    """
+    raw = request.query_params.get("pad_bytes")
+    if raw is None:
+        pad = _env_int("RESPONSE_PAD_BYTES")
+    else:
+        try:
+            pad = int(raw)
+        except ValueError:
+            return None
+        if pad < 0:
+            return None
+    return min(pad, _env_int("MAX_PAD_BYTES", 10 * 1024 * 1024))
+
+
+def get_error_rate() -> float:
+    override = runtime_settings.get("error_rate")
+    if override is not None:
+        return float(override)
+    raw = scenario_env("ERROR_RATE")
+    if not raw:
+        return 0.0
+    try:
+        rate = float(raw)
+    except ValueError:
+        log_event("WARN", f"invalid ERROR_RATE={raw!r}, not injecting errors")
+        return 0.0
+    return min(max(rate, 0.0), 1.0)
+
+
+def _seeded_rng(env_name: str) -> random.Random:
+    """Private RNG seeded from env_name when set, so fault injection is reproducible."""
+    raw = os.getenv(env_name)
+    if raw:
+        try:
+            return random.Random(int(raw))
+        except ValueError:
+            log_event("WARN", f"invalid {env_name}={raw!r}, using a random seed")
+    return random.Random()
+
+
+error_rng = _seeded_rng("ERROR_SEED")
+
+
+def should_inject_error() -> bool:
+    rate = get_error_rate()
+    return rate > 0 and error_rng.random() < rate
+
+
+def get_startup_failure_rate() -> float:
+    raw = os.getenv("STARTUP_FAILURE_RATE")
+    if not raw:
+        return 0.0
+    try:
+        rate = float(raw)
+    except ValueError:
+        log_event("WARN", f"invalid STARTUP_FAILURE_RATE={raw!r}, not failing startup")
+        return 0.0
+    return min(max(rate, 0.0), 1.0)
+
+
+def should_fail_startup(rng: Optional[random.Random] = None) -> bool:
+    """Roll once against STARTUP_FAILURE_RATE, seeded by STARTUP_SEED unless rng is given."""
+    rate = get_startup_failure_rate()
+    if rate <= 0:
+        return False
+    rng = rng or _seeded_rng("STARTUP_SEED")
+    return rng.random() < rate
+
+
+def readiness_delay_seconds(rng: Optional[random.Random] = None) -> Optional[float]:
+    """Uniform warm-up between READINESS_DELAY_MIN_MS and READINESS_DELAY_MAX_MS, or None when unset.
+
+    Drawn from READINESS_SEED unless rng is given; a max below the min uses the min.
+    """
+    low = _env_int("READINESS_DELAY_MIN_MS")
+    high = _env_int("READINESS_DELAY_MAX_MS")
+    if low <= 0 and high <= 0:
+        return None
+    if high < low:
+        log_event("WARN", f"READINESS_DELAY_MAX_MS={high} is below READINESS_DELAY_MIN_MS={low}, using the min")
+        high = low
+    rng = rng or _seeded_rng("READINESS_SEED")
+    return rng.uniform(low, high) / 1000.0
+
+
+def arm_readiness_delay() -> Optional[float]:
+    """Pick the startup readiness delay and hold /readyz at 503 until it has passed."""
+    seconds = readiness_delay_seconds()
+    if seconds is None:
+        return None
+    readiness.set_warmup(seconds)
+    log_event("INFO", "readiness delay chosen", delay_ms=round(seconds * 1000))
+    return seconds
+
+
+latency_rng = _seeded_rng("LATENCY_SEED")
+
+LATENCY_DISTRIBUTIONS = ("constant", "uniform", "normal")
+
+# Reference point for the latency ramp
+process_started = time.monotonic()
+
+
+def latency_ramp_ms(elapsed_seconds: float) -> Optional[int]:
+    """Ramp delay after elapsed_seconds of uptime, or None when no ramp is configured.
+
+    Climbs linearly from LATENCY_RAMP_START_MS to LATENCY_RAMP_END_MS over
+    LATENCY_RAMP_DURATION_SECONDS, then holds at the end value.
+    """
+    if not os.getenv("LATENCY_RAMP_START_MS") and not os.getenv("LATENCY_RAMP_END_MS"):
+        return None
+    start = _env_float("LATENCY_RAMP_START_MS")
+    end = _env_float("LATENCY_RAMP_END_MS")
+    duration = _env_float("LATENCY_RAMP_DURATION_SECONDS")
+    if duration <= 0 or elapsed_seconds >= duration:
+        return round(end)
+    return round(start + (end - start) * max(0.0, elapsed_seconds) / duration)
+
+
+def sample_latency_ms() -> int:
+    """Delay for one /config request, from the latency ramp or drawn from LATENCY_DISTRIBUTION.
+
+    Without either this is RESPONSE_DELAY_MS. LATENCY_MEAN_MS defaults to
+    RESPONSE_DELAY_MS; uniform spans mean +/- LATENCY_STDDEV_MS and normal samples
+    are clamped at zero. A response_delay_ms set through /admin/config replaces the ramp.
+    """
+    ramp = latency_ramp_ms(time.monotonic() - process_started)
+    if ramp is not None and runtime_settings.get("response_delay_ms") is None:
+        return ramp
+    distribution = os.getenv("LATENCY_DISTRIBUTION", "").strip().lower()
+    if not distribution:
+        return get_response_delay_ms()
+    mean = _env_float("LATENCY_MEAN_MS", float(get_response_delay_ms()))
+    stddev = _env_float("LATENCY_STDDEV_MS")
+    if distribution == "uniform":
+        sample = latency_rng.uniform(mean - stddev, mean + stddev)
+    elif distribution == "normal":
+        sample = latency_rng.gauss(mean, stddev)
+    else:
+        if distribution != "constant":
+            log_event("WARN", f"invalid LATENCY_DISTRIBUTION={distribution!r}, using constant")
+        sample = mean
+    return max(0, round(sample))
+
+
+# Statuses that must not carry a body, so /config answers them empty and without a Content-Length
+BODYLESS_STATUSES = {204, 304}
+
+
+def _parse_status(raw: str) -> Optional[int]:
+    """A final status code, 200-599; 1xx are interim responses the server can't send as the reply."""
+    try:
+        code = int(raw)
+    except ValueError:
+        return None
+    return code if 200 <= code <= 599 else None
+
+
+def get_default_status() -> int:
+    raw = os.getenv("DEFAULT_STATUS")
+    if not raw:
+        return 200
+    code = _parse_status(raw)
+    if code is None:
+        log_event("WARN", f"invalid DEFAULT_STATUS={raw!r}, using 200")
+        return 200
+    return code
+
+
+class _StatusSequence:
+    """Cycles /config through the statuses in STATUS_SEQUENCE (e.g. "200,200,500,503"), wrapping at the end.
+
+    The position starts over whenever the sequence changes.
+    """
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._raw: Optional[str] = None
+        self._index = 0
+
+    def next_status(self) -> Optional[int]:
+        """The next status in the sequence, or None when STATUS_SEQUENCE is empty or invalid."""
+        raw = get_status_sequence()
+        if not raw:
+            return None
+        codes = [_parse_status(part.strip()) for part in raw.split(",")]
+        if None in codes:
+            log_event("WARN", f"invalid STATUS_SEQUENCE={raw!r}, ignoring")
+            return None
+        with self._lock:
+            if raw != self._raw:
+                self._raw, self._index = raw, 0
+            code = codes[self._index % len(codes)]
+            self._index += 1
+            return code
+
+
+status_sequence = _StatusSequence()
+
+
+def get_status_sequence() -> str:
+    """The /admin/config status sequence, else STATUS_SEQUENCE."""
+    override = runtime_settings.get("status_sequence")
+    return (override if override is not None else os.getenv("STATUS_SEQUENCE", "")).strip()
+
+
+def get_basic_auth() -> Optional[tuple]:
+    """(user, password) when both BASIC_AUTH_USER and BASIC_AUTH_PASS are set, else None."""
+    user = os.getenv("BASIC_AUTH_USER")
+    password = os.getenv("BASIC_AUTH_PASS")
+    if user and password:
+        return user, password
+    return None
+
+
+def require_basic_auth(request: Request) -> Optional[Response]:
+    """Return a 401 response unless the request carries the configured credentials."""
+    expected = get_basic_auth()
+    if expected is None:
+        return None
+    scheme, _, encoded = request.headers.get("authorization", "").partition(" ")
+    user, password = "", ""
+    if scheme.lower() == "basic":
+        try:
+            user, _, password = base64.b64decode(encoded, validate=True).decode("utf-8").partition(":")
+        except (ValueError, UnicodeDecodeError):
+            pass
+    # Compare both fields unconditionally so timing doesn't reveal which one was wrong
+    user_ok = hmac.compare_digest(user.encode(), expected[0].encode())
+    password_ok = hmac.compare_digest(password.encode(), expected[1].encode())
+    if user_ok & password_ok:
+        return None
+    log_event("WARN", "rejected request with missing or invalid credentials", path=request.url.path, status=401)
+    return error_response(
+        request, 401, "unauthorized", "unauthorized", headers={"WWW-Authenticate": 'Basic realm="alert-example"'}
+    )
+
+
+def _accepts_json(request: Request) -> bool:
+    return "application/json" in request.headers.get("accept", "")
+
+
+def error_response(request: Request, status: int, code: str, message: str, **kwargs) -> Response:
+    """An error as {"error": {"code", "message", "request_id"}} for JSON clients, else plain text.
+
+    Extra keyword arguments (headers, background) are passed to the Response.
+    """
+    if _accepts_json(request):
+        envelope = {"error": {"code": code, "message": message, "request_id": request_id_from_context()}}
+        return JSONResponse(envelope, status_code=status, **kwargs)
+    return Response(content=message, status_code=status, media_type="text/plain; charset=utf-8", **kwargs)
+
+
+async def _sleep_unless_disconnected(request: Request, seconds: float) -> bool:
+    """Sleep for up to seconds; return False early if the client disconnects meanwhile."""
+    loop = asyncio.get_running_loop()
+    deadline = loop.time() + seconds
+    while True:
+        remaining = deadline - loop.time()
+        if remaining <= 0:
+            return True
+        if await request.is_disconnected():
+            return False
+        await asyncio.sleep(min(remaining, 0.05))
+
+
+class ConfigReadTimeout(Exception):
+    """The config read did not finish within CONFIG_READ_TIMEOUT_MS."""
+
+
+def _discard_result(future: asyncio.Future) -> None:
+    # Retrieve the exception of an abandoned read so asyncio doesn't log it as unhandled
+    if not future.cancelled():
+        future.exception()
+
+
+async def read_config_for_request(request: Request) -> Optional[str]:
+    """read_config on a worker thread, bounded by CONFIG_READ_TIMEOUT_MS when set.
+
+    Returns None if the client disconnects first; raises ConfigReadTimeout once the
+    deadline passes. A read that is given up on keeps running in its thread, like an
+    abandoned goroutine, but its result is discarded.
+    """
+    timeout_ms = _env_int("CONFIG_READ_TIMEOUT_MS")
+    if timeout_ms <= 0:
+        return await asyncio.to_thread(read_config)
+    read = asyncio.ensure_future(asyncio.to_thread(read_config))
+    loop = asyncio.get_running_loop()
+    deadline = loop.time() + timeout_ms / 1000.0
+    while True:
+        # Poll like _sleep_unless_disconnected so a departed client frees the handler early
+        remaining = deadline - loop.time()
+        if remaining <= 0:
+            read.add_done_callback(_discard_result)
+            raise ConfigReadTimeout(f"config read exceeded CONFIG_READ_TIMEOUT_MS={timeout_ms}")
+        done, _ = await asyncio.wait({read}, timeout=min(remaining, 0.05))
+        if done:
+            return read.result()
+        if await request.is_disconnected():
+            read.add_done_callback(_discard_result)
+            return None
+
+
+GZIP_MIN_BYTES = 1024
+
+
+def _accepts_gzip(request: Request) -> bool:
+    for coding in request.headers.get("accept-encoding", "").split(","):
+        name, _, params = coding.strip().partition(";")
+        if name.strip().lower() == "gzip":
+            return params.replace(" ", "") not in ("q=0", "q=0.0")
+    return False
+
+
+def gzip_response(request: Request, response: Response) -> Response:
+    """Compress a buffered response body when the client accepts gzip and it's worth it."""
+    body = getattr(response, "body", None)
+    if body is None or len(body) < GZIP_MIN_BYTES or "content-encoding" in response.headers:
+        return response
+    response.headers.add_vary_header("Accept-Encoding")
+    if not _accepts_gzip(request):
+        return response
+    response.body = gzip.compress(body)
+    response.headers["Content-Encoding"] = "gzip"
+    etag = response.headers.get("etag")
+    if etag and not etag.startswith("W/"):
+        # The tag identifies the uncompressed bytes, so it is only weakly valid for the gzip encoding
+        response.headers["ETag"] = "W/" + etag
+    response.headers["Content-Length"] = str(len(response.body))
+    return response
+
+
+def _etag_matches(if_none_match: str, etag: str) -> bool:
+    if if_none_match.strip() == "*":
+        return True
+    return any(tag.strip().removeprefix("W/") == etag for tag in if_none_match.split(","))
+
+
+def conditional_response(request: Request, response: Response) -> Response:
+    """Tag a 200 response with a SHA-256 ETag and answer a matching If-None-Match with 304."""
+    body = getattr(response, "body", None)
+    if response.status_code != 200 or body is None:
+        return response
+    etag = f'"{hashlib.sha256(body).hexdigest()}"'
+    response.headers["ETag"] = etag
+    if _etag_matches(request.headers.get("if-none-match", ""), etag):
+        return Response(status_code=304, headers={"ETag": etag})
+    return response
+
+
+def chunked_response(response: Response, chunk_size: int) -> Response:
+    """Re-send a buffered response as a stream of chunk_size pieces, so it goes out with
+    Transfer-Encoding: chunked instead of a Content-Length."""
+    body = response.body
+
+    async def chunks():
+        for start in range(0, len(body), chunk_size):
+            yield body[start : start + chunk_size]
+
+    headers = {key: value for key, value in response.headers.items() if key != "content-length"}
+    # Keeps the background task too, e.g. the crash exit behind a crash-armed 500
+    return StreamingResponse(
+        chunks(), status_code=response.status_code, headers=headers, background=response.background
+    )
+
+
+# Framing and content headers the app computes itself; RESPONSE_HEADERS may not replace them
+RESERVED_RESPONSE_HEADERS = frozenset(
+    {"content-length", "content-type", "content-encoding", "transfer-encoding", "connection"}
+)
+_HEADER_NAME = re.compile(r"^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+
+def parse_response_headers(raw: str) -> dict:
+    """Parse RESPONSE_HEADERS ("Key1:Val1,Key2:Val2"), skipping malformed and reserved pairs with a warning."""
+    headers = {}
+    for pair in raw.split(","):
+        if not pair.strip():
+            continue
+        name, sep, value = pair.partition(":")
+        name, value = name.strip(), value.strip()
+        if not sep or not _HEADER_NAME.match(name) or any(c in value for c in "\r\n"):
+            log_event("WARN", f"skipping malformed RESPONSE_HEADERS entry {pair.strip()!r}")
+            continue
+        if name.lower() in RESERVED_RESPONSE_HEADERS:
+            log_event("WARN", f"skipping reserved header {name} in RESPONSE_HEADERS")
+            continue
+        headers[name] = value
+    return headers
+
+
+# Parsed once by main(); applied to every /config response
+response_headers: dict = {}
+
+
+def _encode_config_response(request: Request, response: Response) -> Response:
+    """Apply conditional GET, gzip, ?chunked=true chunked transfer encoding and RESPONSE_HEADERS."""
+    response = gzip_response(request, conditional_response(request, response))
+    if request.query_params.get("chunked", "").lower() == "true" and getattr(response, "body", b""):
+        response = chunked_response(response, _env_int("CHUNK_SIZE_BYTES", 64) or 64)
+    for name, value in response_headers.items():
+        response.headers[name] = value
+    return response
+
+
+# Retry-After sent with load-shedding 503s
+SHED_RETRY_AFTER_SECONDS = 1
+
+
+def shedding_threads() -> Optional[int]:
+    """The live thread count when it exceeds MAX_GOROUTINES, else None.
+
+    Named after the Go example's goroutine limit; here it counts Python threads.
+    """
+    limit = _env_int("MAX_GOROUTINES")
+    count = threading.active_count()
+    if limit > 0 and count > limit:
+        return count
+    return None
+
+
+@app.get("/config")
+async def get_config(request: Request) -> Response:
+    threads = shedding_threads()
+    if threads is not None:
+        log_event("WARN", "thread limit exceeded, shedding load", path="/config", status=503, threads=threads)
+        return error_response(
+            request, 503, "overloaded", "server overloaded", headers={"Retry-After": str(SHED_RETRY_AFTER_SECONDS)}
+        )
+    limit = _env_int("MAX_CONCURRENT_REQUESTS")
+    if limit <= 0:
+        return _encode_config_response(request, await _config_response(request))
+    if _env_flag("OVERFLOW_REJECT"):
+        if not concurrency.try_acquire(limit):
+            log_event("WARN", "concurrency limit reached, rejecting", path="/config", status=503, limit=limit)
+            return error_response(request, 503, "too_many_requests", "too many concurrent requests")
+    else:
+        await concurrency.acquire(limit)
+    try:
+        return _encode_config_response(request, await _config_response(request))
+    finally:
+        concurrency.release()
+
+
+def _crash_response(request: Request, data: str = "") -> Response:
+    """The 500 for a crash-armed config, terminating or degrading health per CRASH_MODE.
+
+    The crash log line names the triggering request, its peer address and the matched keywords.
+    X-Forwarded-For is client-supplied, so it is logged as forwarded_for beside the peer, never in its place.
+    """
+    matched = matched_crash_keywords(data)
+    trigger = {
+        "path": request.url.path,
+        "request_id": request_id_from_context(),
+        "remote_addr": request.client.host if request.client else "unknown",
+        "keywords_matched": ",".join(matched) if matched else "CRASH_ARMED",
+    }
+    forwarded = request.headers.get("x-forwarded-for")
+    if forwarded:
+        trigger["forwarded_for"] = forwarded
+    if get_crash_mode() == "degrade":
+        degrade_health("config contained crash keyword", status=500, **trigger)
+        return error_response(request, 500, "crash_triggered", "config triggered crash")
+    log_event("ERROR", "config contained crash keyword, terminating", status=500, **trigger)
+    # Scheduled once the response has been written, so CRASH_DELAY_MS=0 still delivers the 500
+    return error_response(
+        request, 500, "crash_triggered", "config triggered crash", background=BackgroundTask(schedule_crash_exit)
+    )
+
+
+async def _config_response(request: Request) -> Response:
+    with runtime_settings.snapshot():
+        return await _serve_config(request)
+
+
+async def _serve_config(request: Request) -> Response:
+    allowed, retry_after = rate_limiter.allow(client_ip(request))
+    if not allowed:
+        log_event("WARN", "rate limit exceeded", path="/config", status=429, client=client_ip(request))
+        return error_response(
+            request, 429, "rate_limited", "rate limit exceeded", headers={"Retry-After": str(retry_after)}
+        )
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    health_flip.record_config_served()
+    raw_status = request.query_params.get("status")
+    status_code = None if raw_status is not None else status_sequence.next_status()
+    if status_code is None:
+        status_code = get_default_status()
+    if raw_status is not None:
+        status_code = _parse_status(raw_status)
+        if status_code is None:
+            return error_response(
+                request, 400, "invalid_status", "invalid status: must be an integer between 200 and 599"
+            )
+
+    delay_ms = sample_latency_ms()
+    raw_delay = request.query_params.get("delay_ms")
+    if raw_delay is not None:
+        try:
+            delay_ms = int(raw_delay)
+        except ValueError:
+            delay_ms = -1
+        if delay_ms < 0:
+            return error_response(request, 400, "invalid_delay", "invalid delay_ms")
+    pad_bytes = get_pad_bytes(request)
+    if pad_bytes is None:
+        return error_response(request, 400, "invalid_pad_bytes", "invalid pad_bytes")
+    if delay_ms > 0 and not await _sleep_unless_disconnected(request, delay_ms / 1000.0):
+        log_event("INFO", "client disconnected during response delay", path="/config", delay_ms=delay_ms)
+        # nginx's convention for "client closed request"; nobody is left to read it
+        return Response(status_code=499)
+
+    # Before the read, so injected failures work even with no config on disk
+    if should_inject_error():
+        log_event("WARN", "injecting error", path="/config", status=500)
+        return error_response(request, 500, "injected_error", "injected error")
+
+    template = os.getenv("RESPONSE_TEMPLATE")
+    if template:
+        try:
+            data = render_response_template(template)
+        except ValueError as e:
+            log_event("ERROR", f"failed to render RESPONSE_TEMPLATE: {e}", path="/config", status=500)
+            return error_response(request, 500, "template_error", f"failed to render template: {e}")
+    else:
+        try:
+            data = await read_config_for_request(request)
+        except ConfigReadTimeout as e:
+            log_event("ERROR", str(e), path="/config", status=504, config_path=get_config_path())
+            return error_response(request, 504, "config_read_timeout", "config read timed out")
+        except ConfigTooLarge as e:
+            log_event("ERROR", str(e), path="/config", status=413, config_path=get_config_path())
+            return error_response(request, 413, "config_too_large", "config too large")
+        except FileNotFoundError as e:
+            if _env_flag("REQUIRE_CONFIG", default=True):
+                log_event("ERROR", f"failed to read config file {get_config_path()}: {e}", path="/config", status=500)
+                return error_response(request, 500, "config_read_failed", "failed to read config")
+            # An optional config that hasn't arrived yet is a temporary condition, not a failure
+            log_event("WARN", f"config file {get_config_path()} not present yet", path="/config", status=503)
+            return error_response(request, 503, "config_unavailable", "config not available yet")
+        except Exception as e:
+            log_event("ERROR", f"failed to read config file {get_config_path()}: {e}", path="/config", status=500)
+            return error_response(request, 500, "config_read_failed", "failed to read config")
+        if data is None:
+            log_event("INFO", "client disconnected during config read", path="/config")
+            return Response(status_code=499)
+
+    crash = should_crash(data)
+    metrics.set_crash_armed(crash)
+    request.state.crash_matched = crash
+
+    # Emit a root-level trace span when config contains "Error"
+    if "Error" in data:
+        emit_error_span(data)
+        # Exit non-zero shortly after returning, to allow HTTP response and span export
+        def _exit_after_flush() -> None:
+            try:
+                time.sleep(5.0)
+            finally:
+                exit_func(1)
+        threading.Thread(target=_exit_after_flush, daemon=True).start()
+        return error_response(request, 500, "config_error", "config triggered error; exiting")
+
+    if crash:
+        return _crash_response(request, data)
+
+    if _env_flag("VALIDATE_YAML"):
+        error = yaml_error(data)
+        if error is not None:
+            log_event("WARN", "config is not valid YAML", path="/config", status=422)
+            return error_response(request, 422, "invalid_yaml", f"invalid YAML: {error}")
+
+    if status_code in BODYLESS_STATUSES:
+        return Response(status_code=status_code)
+
+    if _accepts_json(request):
+        try:
+            parsed = yaml.safe_load(data)
+        except yaml.YAMLError:
+            log_event("INFO", "config is not valid YAML, serving raw text despite Accept: application/json", path="/config")
+        else:
+            try:
+                body = json.dumps(parsed, default=_yaml_json_default)
+            except (TypeError, ValueError) as e:
+                log_event("ERROR", f"failed to convert config to JSON: {e}", path="/config", status=500)
+                return error_response(request, 500, "json_conversion_failed", f"failed to convert config to JSON: {e}")
+            return Response(content=body, status_code=status_code, media_type="application/json")
+
+    # Only the text body is padded; filler would make the JSON body unparseable
+    body = data + PAD_BYTE * pad_bytes
+    return Response(content=body, status_code=status_code, media_type="text/plain; charset=utf-8")
+
+
+def _yaml_json_default(value):
+    """Serialize YAML timestamps (date/datetime) as ISO 8601; any other non-JSON type still fails."""
+    if isinstance(value, (date, datetime)):
+        return value.isoformat()
+    raise TypeError(f"Object of type {type(value).__name__} is not JSON serializable")
+
+
+@app.post("/config/reload")
+def handle_reload(request: Request) -> Response:
+    try:
+        crash = reload_config("reload requested")
+    except Exception as e:
+        log_event("ERROR", f"config reload failed: {e}", path="/config/reload", status=500)
+        return error_response(request, 500, "config_reload_failed", f"config reload failed: {e}")
+    return JSONResponse({"success": True, "crash_armed": crash})
+
+
+@app.post("/config/validate")
+async def handle_validate(request: Request) -> Response:
+    """Report whether a candidate config would arm the crash, without ever crashing."""
+    candidate = (await request.body()).decode("utf-8", errors="replace")
+    return JSONResponse({"crash_armed": should_crash(candidate), "keywords_matched": matched_crash_keywords(candidate)})
+
+
+@app.post("/config/diff")
+async def handle_diff(request: Request) -> Response:
+    """Unified diff from the current config to a candidate body, plus whether the candidate arms the crash."""
+    # The diff quotes the current config, so it gets the same protection as /config
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    candidate = (await request.body()).decode("utf-8", errors="replace")
+    try:
+        current = await asyncio.to_thread(read_config)
+    except Exception as e:
+        log_event("ERROR", f"failed to read config for diff: {e}", path="/config/diff", status=500)
+        return error_response(request, 500, "config_read_failed", "failed to read config")
+    diff = "".join(
+        difflib.unified_diff(
+            current.splitlines(keepends=True), candidate.splitlines(keepends=True), "current", "candidate"
+        )
+    )
+    return JSONResponse(
+        {
+            "changed": bool(diff),
+            "diff": diff,
+            "crash_armed": should_crash(candidate),
+            "keywords_matched": matched_crash_keywords(candidate),
+        }
+    )
+
+
+class _EchoHistory:
+    """Ring buffer of the last ECHO_HISTORY_SIZE (default 20) bodies posted to /echo."""
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._entries: collections.deque = collections.deque()
+
+    def record(self, entry: dict) -> None:
+        size = _env_int("ECHO_HISTORY_SIZE", 20)
+        with self._lock:
+            self._entries.append(entry)
+            while len(self._entries) > size:
+                self._entries.popleft()
+
+    def entries(self) -> list:
+        with self._lock:
+            return list(self._entries)
+
+
+echo_history = _EchoHistory()
+
+
+async def _read_body_limited(request: Request, limit: int) -> Optional[bytes]:
+    """The request body, or None as soon as it grows past limit bytes."""
+    chunks, size = [], 0
+    async for chunk in request.stream():
+        size += len(chunk)
+        if size > limit:
+            return None
+        chunks.append(chunk)
+    return b"".join(chunks)
+
+
+@app.post("/echo")
+async def handle_echo(request: Request) -> Response:
+    """Record a webhook body and send it straight back with the same Content-Type."""
+    limit = _env_int("MAX_CONFIG_BYTES", 1024 * 1024)
+    body = await _read_body_limited(request, limit)
+    if body is None:
+        log_event("WARN", "echo body too large", path="/echo", status=413, limit=limit)
+        return error_response(request, 413, "body_too_large", "request body too large")
+    content_type = request.headers.get("content-type", "application/octet-stream")
+    text = body.decode("utf-8", errors="replace")
+    log_event("INFO", "echo received", path="/echo", content_type=content_type, bytes=len(body), body=text)
+    echo_history.record(
+        {
+            "received_at": datetime.now(timezone.utc).isoformat(timespec="milliseconds").replace("+00:00", "Z"),
+            "content_type": content_type,
+            "body": text,
+        }
+    )
+    return Response(content=body, headers={"Content-Type": content_type})
+
+
+@app.get("/echo/history")
+def handle_echo_history() -> Response:
+    return JSONResponse(echo_history.entries())
+
+
+def forward_alert(url: str, body: bytes, content_type: str) -> tuple:
+    """POST body to url, retrying connection errors and 5xx with exponential backoff.
+
+    Makes up to 1 + FORWARD_RETRIES (default 3) attempts, waiting FORWARD_BACKOFF_MS (default 100)
+    doubled after each failure, all within FORWARD_TIMEOUT_SECONDS (default 10). Returns
+    (status, body, content_type) of the last downstream response, or a 502/504 when none arrived.
+    """
+    retries = _env_int("FORWARD_RETRIES", 3)
+    backoff = _env_int("FORWARD_BACKOFF_MS", 100) / 1000.0
+    deadline = time.monotonic() + _env_float("FORWARD_TIMEOUT_SECONDS", 10.0)
+    result = (502, b"forward failed", "text/plain; charset=utf-8")
+    for attempt in range(1, retries + 2):
+        remaining = deadline - time.monotonic()
+        if remaining <= 0:
+            return 504, b"forward timed out", "text/plain; charset=utf-8"
+        try:
+            resp = requests.post(url, data=body, headers={"Content-Type": content_type}, timeout=remaining)
+        except requests.RequestException as e:
+            log_event("WARN", f"alert forward attempt failed: {e}", path="/alert", attempt=attempt, forward_url=url)
+        else:
+            result = (resp.status_code, resp.content, resp.headers.get("content-type", "application/octet-stream"))
+            level = "WARN" if resp.status_code >= 500 else "INFO"
+            log_event(
+                level, "alert forwarded", path="/alert", attempt=attempt, forward_url=url, status=resp.status_code
+            )
+            if resp.status_code < 500:
+                return result
+        if attempt <= retries:
+            delay = backoff * 2 ** (attempt - 1)
+            if time.monotonic() + delay >= deadline:
+                return 504, b"forward timed out", "text/plain; charset=utf-8"
+            time.sleep(delay)
+    return result
+
+
+@app.post("/alert")
+async def handle_alert(request: Request) -> Response:
+    """Relay a received alert to FORWARD_URL and answer with the downstream status."""
+    url = os.getenv("FORWARD_URL")
+    if not url:
+        return Response(content="alert forwarding is disabled", status_code=404)
+    limit = _env_int("MAX_CONFIG_BYTES", 1024 * 1024)
+    body = await _read_body_limited(request, limit)
+    if body is None:
+        log_event("WARN", "alert body too large", path="/alert", status=413, limit=limit)
+        return error_response(request, 413, "body_too_large", "request body too large")
+    content_type = request.headers.get("content-type", "application/octet-stream")
+    status, content, downstream_type = await asyncio.to_thread(forward_alert, url, body, content_type)
+    return Response(content=content, status_code=status, headers={"Content-Type": downstream_type})
+
+
+def schedule_crash_exit() -> None:
+    """Exit non-zero after CRASH_DELAY_MS (default 500); a delay of 0 exits immediately."""
+    exit_code = get_crash_exit_code()
+    delay_ms = _env_int("CRASH_DELAY_MS", 500)
+    log_event("INFO", "crash exit scheduled", exit_code=exit_code, delay_ms=delay_ms)
+    if delay_ms == 0:
+        exit_func(exit_code)
+        return
+
+    def _delayed_exit() -> None:
+        time.sleep(delay_ms / 1000.0)
+        exit_func(exit_code)
+
+    threading.Thread(target=_delayed_exit, daemon=True).start()
+
+
+def crash_after_seconds(rng: Optional[random.Random] = None) -> Optional[float]:
+    """CRASH_AFTER_SECONDS plus uniform ±CRASH_JITTER_SECONDS, or None when no timed crash is set.
+
+    The jitter is drawn from CRASH_SEED unless rng is given, and never brings the delay below zero.
+    """
+    seconds = _env_float("CRASH_AFTER_SECONDS")
+    if seconds <= 0:
+        return None
+    jitter = _env_float("CRASH_JITTER_SECONDS")
+    if jitter > 0:
+        rng = rng or _seeded_rng("CRASH_SEED")
+        seconds = max(0.0, seconds + rng.uniform(-jitter, jitter))
+    return seconds
+
+
+def start_crash_timer() -> Optional[threading.Timer]:
+    """Exit after CRASH_AFTER_SECONDS of uptime regardless of config, for a steady crash loop."""
+    seconds = crash_after_seconds()
+    if seconds is None:
+        return None
+
+    def _fire() -> None:
+        exit_code = get_crash_exit_code()
+        log_event("ERROR", "crash timer expired, terminating", after_seconds=seconds, exit_code=exit_code)
+        exit_func(exit_code)
+
+    timer = threading.Timer(seconds, _fire)
+    timer.daemon = True
+    timer.start()
+    crash_at = datetime.now(timezone.utc) + timedelta(seconds=seconds)
+    log_event(
+        "INFO",
+        "crash timer armed",
+        after_seconds=round(seconds, 3),
+        crash_at=crash_at.isoformat(timespec="milliseconds").replace("+00:00", "Z"),
+    )
+    return timer
+
+
+class ConfigWatcher:
+    """Calls on_change after the config path changes on disk.
+
+    Polls os.stat rather than using inotify: Kubernetes updates mounted ConfigMaps by
+    swapping a symlink, which stat-following picks up reliably. Changes are debounced
+    so a burst of writes produces a single callback. paths returns the files to watch,
+    by default the config files.
+    """
+
+    def __init__(self, on_change, poll_interval: float = 0.05, debounce: float = 0.2, paths=None) -> None:
+        self._on_change = on_change
+        self._paths = paths or (lambda: config_files(get_config_path()))
+        self._poll_interval = poll_interval
+        self._debounce = debounce
+        self._stop = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+
+    def start(self) -> None:
+        # The baseline is taken here, not in the thread, so a change right after start() is never missed
+        self._thread = threading.Thread(target=self._run, args=(self._signature(),), name="config-watcher", daemon=True)
+        self._thread.start()
+
+    def stop(self) -> None:
+        self._stop.set()
+        if self._thread is not None:
+            self._thread.join(timeout=1)
+
+    def running(self) -> bool:
+        return self._thread is not None and self._thread.is_alive()
+
+    def _signature(self) -> Optional[tuple]:
+        signature = []
+        try:
+            for file_path in self._paths():
+                st = os.stat(file_path)
+                signature.append((file_path, st.st_ino, st.st_size, st.st_mtime_ns))
+        except OSError:
+            return None
+        return tuple(signature)
+
+    def _run(self, last: Optional[tuple]) -> None:
+        changed_at = None
+        while not self._stop.wait(self._poll_interval):
+            current = self._signature()
+            if current != last:
+                last = current
+                changed_at = time.monotonic()
+            elif changed_at is not None and time.monotonic() - changed_at >= self._debounce:
+                changed_at = None
+                try:
+                    self._on_change()
+                except Exception as e:
+                    log_event("WARN", f"config change handler failed: {e}")
+
+
+@app.get("/config/watch")
+async def handle_config_watch(request: Request) -> Response:
+    """Long-poll: answer with the new config once it changes, or 304 after ?timeout= seconds (default 30)."""
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    try:
+        timeout = float(request.query_params.get("timeout", "30"))
+    except ValueError:
+        timeout = -1
+    if not 0 <= timeout < math.inf:
+        return error_response(request, 400, "invalid_timeout", "timeout must be a non-negative number of seconds")
+
+    loop = asyncio.get_running_loop()
+    changed = asyncio.Event()
+    watcher = ConfigWatcher(lambda: loop.call_soon_threadsafe(changed.set))
+    watcher.start()
+    try:
+        deadline = loop.time() + timeout
+        while not changed.is_set():
+            remaining = deadline - loop.time()
+            if remaining <= 0:
+                return Response(status_code=304)
+            if await request.is_disconnected():
+                log_event("INFO", "client disconnected during config watch", path="/config/watch")
+                return Response(status_code=499)
+            try:
+                await asyncio.wait_for(changed.wait(), min(remaining, 0.05))
+            except asyncio.TimeoutError:
+                pass
+    finally:
+        await asyncio.to_thread(watcher.stop)
+
+    try:
+        data = await asyncio.to_thread(read_config)
+    except Exception as e:
+        log_event("ERROR", f"failed to read config file {get_config_path()}: {e}", path="/config/watch", status=500)
+        return error_response(request, 500, "config_read_failed", "failed to read config")
+    return Response(content=data, media_type="text/plain; charset=utf-8")
+
+
+class _ConfigHistory:
+    """Ring buffer of the last CONFIG_HISTORY_SIZE (default 20) distinct configs seen on reload."""
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._entries: collections.deque = collections.deque()
+
+    def record(self, content: str, reason: str) -> bool:
+        """Append content unless it matches the newest entry; return whether it was added."""
+        digest = hashlib.sha256(content.encode("utf-8")).hexdigest()
+        size = _env_int("CONFIG_HISTORY_SIZE", 20)
+        with self._lock:
+            if self._entries and self._entries[-1]["sha256"] == digest:
+                return False
+            self._entries.append(
+                {
+                    "observed_at": datetime.now(timezone.utc).isoformat(timespec="milliseconds").replace("+00:00", "Z"),
+                    "reason": reason,
+                    "sha256": digest,
+                    "content": content,
+                }
+            )
+            while len(self._entries) > size:
+                self._entries.popleft()
+            return True
+
+    def entries(self) -> list:
+        with self._lock:
+            return list(self._entries)
+
+
+config_history = _ConfigHistory()
+
+
+@app.get("/config/history")
+def handle_config_history(request: Request) -> Response:
+    # The entries hold full config contents, so they get the same protection as /config
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    return JSONResponse(config_history.entries())
+
+
+def reload_config(reason: str) -> bool:
+    """Re-read the config and refresh crash state, terminating if it is now crash-armed.
+
+    Returns whether the crash keyword is present; read errors propagate to the caller.
+    """
+    data = read_config()
+    config_history.record(data, reason)
+    crash = should_crash(data)
+    metrics.set_crash_armed(crash)
+    log_event("INFO", "config reloaded", reason=reason, config_path=get_config_path(), crash_armed=crash)
+    if crash and get_crash_mode() == "degrade":
+        degrade_health("reloaded config contained crash keyword", config_path=get_config_path())
+    elif crash:
+        log_event("ERROR", "reloaded config contained crash keyword, terminating", config_path=get_config_path())
+        schedule_crash_exit()
+    return crash
+
+
+def handle_config_change() -> None:
+    try:
+        reload_config("file changed")
+    except Exception as e:
+        log_event("WARN", f"config changed but could not be read: {e}", config_path=get_config_path())
+
+
+config_watcher: Optional[ConfigWatcher] = None
+
+
+class CrashScanner:
+    """Finds crash keywords in a byte stream fed chunk by chunk.
+
+    Only the last len(longest keyword) - 1 bytes are carried between chunks, so a keyword
+    split across a chunk boundary is still found without holding the whole stream.
+    """
+
+    def __init__(self, keywords: list) -> None:
+        self._keywords = [(keyword, keyword.encode("utf-8")) for keyword in keywords]
+        self._keep = max((len(encoded) for _, encoded in self._keywords), default=1) - 1
+        self._tail = b""
+        self.matched: list = []
+
+    def feed(self, chunk: bytes) -> bool:
+        """Scan the next chunk; True once any keyword has been seen."""
+        window = self._tail + chunk
+        for keyword, encoded in self._keywords:
+            if keyword not in self.matched and encoded in window:
+                self.matched.append(keyword)
+        self._tail = window[-self._keep :] if self._keep else b""
+        return bool(self.matched)
+
+
+class ResponseCut(Exception):
+    """Raised from a body generator to drop the connection once the response has started.
+
+    _ResponseCutMiddleware stops it before the server, which would log it as an application error.
+    """
+
+
+class ConfigStreamAborted(ResponseCut):
+    """A crash keyword turned up part way through /config/stream."""
+
+
+# Read size for /config/stream; each chunk is written before the next is read
+STREAM_CHUNK_BYTES = 64 * 1024
+
+
+@app.get("/config/stream")
+async def handle_config_stream(request: Request) -> Response:
+    """Stream the config file without loading it into memory, scanning for crash keywords as it goes.
+
+    A keyword found mid-stream aborts the response short of its Content-Length and then
+    terminates or degrades per CRASH_MODE; a client disconnect stops reading the file.
+    """
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    if crash_armed_by_scenario():
+        return _crash_response(request)
+    path = get_config_path()
+    if is_config_url(path) or os.path.isdir(path):
+        return error_response(request, 400, "not_streamable", "config streaming needs a single CONFIG_PATH file")
+    try:
+        f = open(path, "rb")
+        size = os.fstat(f.fileno()).st_size
+    except OSError as e:
+        readiness.record_config_read(False)
+        log_event("ERROR", f"failed to open config for streaming: {e}", path="/config/stream", status=500)
+        return error_response(request, 500, "config_read_failed", "failed to read config")
+    readiness.record_config_read(True)
+
+    async def stream():
+        scanner = CrashScanner(get_crash_keywords())
+        sent = 0
+        try:
+            while True:
+                if await request.is_disconnected():
+                    log_event("INFO", "client disconnected, stopping config stream", path="/config/stream", sent=sent)
+                    return
+                # Never past the Content-Length taken at open, even if the file has grown since
+                remaining = size - sent
+                if remaining <= 0:
+                    return
+                chunk = await asyncio.to_thread(f.read, min(STREAM_CHUNK_BYTES, remaining))
+                if not chunk:
+                    return
+                if scanner.feed(chunk):
+                    _abort_config_stream(scanner.matched, sent)
+                sent += len(chunk)
+                yield chunk
+        finally:
+            f.close()
+
+    return StreamingResponse(stream(), media_type="text/plain; charset=utf-8", headers={"Content-Length": str(size)})
+
+
+def _abort_config_stream(matched: list, sent: int) -> None:
+    fields = {"path": "/config/stream", "keywords_matched": ",".join(matched), "sent": sent}
+    if get_crash_mode() == "degrade":
+        degrade_health("streamed config contained crash keyword", **fields)
+    else:
+        log_event("ERROR", "streamed config contained crash keyword, terminating", **fields)
+        schedule_crash_exit()
+    raise ConfigStreamAborted(f"crash keyword {matched[0]!r} after {sent} bytes")
+
+
+class ResponseTruncated(ResponseCut):
+    """/config/truncated cutting its response short on purpose."""
+
+
+def get_truncate_bytes(request: Request, body_size: int) -> Optional[int]:
+    """Bytes /config/truncated sends, from ?bytes= or TRUNCATE_BYTES (default half the body); None if invalid.
+
+    Always at least one byte short of the body, so the response is never complete.
+    """
+    raw = request.query_params.get("bytes") or os.getenv("TRUNCATE_BYTES")
+    if not raw:
+        return body_size // 2
+    try:
+        count = int(raw)
+    except ValueError:
+        return None
+    if count < 0:
+        return None
+    return min(count, max(body_size - 1, 0))
+
+
+@app.get("/config/truncated")
+async def handle_config_truncated(request: Request) -> Response:
+    """Send the config's full Content-Length but only its first bytes, then drop the connection.
+
+    The closest ASGI gets to hijacking the connection: raising ResponseCut once the response has
+    started has the transport closed without ending the message.
+    """
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    if crash_armed_by_scenario():
+        return _crash_response(request)
+    try:
+        config_data = await asyncio.to_thread(read_config)
+    except Exception as e:
+        log_event("ERROR", f"failed to read config file {get_config_path()}: {e}", path="/config/truncated", status=500)
+        return error_response(request, 500, "config_read_failed", "failed to read config")
+    if should_crash(config_data):
+        return _crash_response(request, config_data)
+    data = config_data.encode("utf-8")
+    cut = get_truncate_bytes(request, len(data))
+    if cut is None:
+        return error_response(request, 400, "invalid_truncate_bytes", "bytes must be a non-negative integer")
+
+    async def truncated():
+        yield data[:cut]
+        log_event("INFO", "truncating response, closing connection", path="/config/truncated", sent=cut, size=len(data))
+        raise ResponseTruncated(f"closed after {cut} of {len(data)} bytes")
+
+    return StreamingResponse(
+        truncated(), media_type="text/plain; charset=utf-8", headers={"Content-Length": str(len(data))}
+    )
+
+
+def named_config_path(name: str) -> Optional[str]:
+    """CONFIG_PATH/<name>.yaml, or None if name would escape the config directory.
+
+    The directory is CONFIG_PATH itself, or the directory holding it when it is a file.
+    """
+    if not name or ".." in name or "/" in name or "\\" in name or "\0" in name:
+        return None
+    base = get_config_path()
+    if not os.path.isdir(base):
+        base = os.path.dirname(base)
+    base = os.path.realpath(base)
+    path = os.path.realpath(os.path.join(base, f"{name}.yaml"))
+    # Also refuses symlinks pointing outside the directory
+    if os.path.dirname(path) != base:
+        return None
+    return path
+
+
+# Declared after the fixed /config/* routes so they are matched first
+@app.get("/config/{name:path}")
+async def handle_named_config(request: Request, name: str) -> Response:
+    """Serve CONFIG_PATH/<name>.yaml, with the same crash-keyword handling as /config."""
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    if is_config_url(get_config_path()):
+        return error_response(request, 400, "not_named", "named configs need a CONFIG_PATH file or directory")
+    path = named_config_path(name)
+    if path is None:
+        log_event("WARN", "rejected named config outside the config directory", path=request.url.path, status=400)
+        return error_response(request, 400, "invalid_name", "invalid config name")
+    try:
+        data = await asyncio.to_thread(_read_config_files, path, _env_int("MAX_CONFIG_BYTES", 1024 * 1024))
+    except ConfigTooLarge as e:
+        log_event("ERROR", str(e), path=request.url.path, status=413, config_path=path)
+        return error_response(request, 413, "config_too_large", "config too large")
+    except FileNotFoundError:
+        return error_response(request, 404, "config_not_found", f"no config named {name}")
+    except Exception as e:
+        log_event("ERROR", f"failed to read config file {path}: {e}", path=request.url.path, status=500)
+        return error_response(request, 500, "config_read_failed", "failed to read config")
+    if should_crash(data):
+        return _crash_response(request, data)
+    return _encode_config_response(request, Response(content=data, media_type="text/plain; charset=utf-8"))
+
+
+_MB = 1024 * 1024
+
+
+class _MemoryLeak:
+    """Deliberately retains memory at a fixed rate, to drive memory-pressure alerts."""
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._chunks: list = []
+        self._allocated_bytes = 0
+        self._mb_per_sec = 0.0
+        self._stop = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+
+    def start(self, mb_per_sec: float, interval: float = 1.0) -> None:
+        self._mb_per_sec = mb_per_sec
+        self._stop.clear()
+        self._thread = threading.Thread(target=self._run, args=(interval,), name="memory-leak", daemon=True)
+        self._thread.start()
+        log_event("WARN", "leaking memory", mb_per_sec=mb_per_sec)
+
+    def stop(self) -> None:
+        self._stop.set()
+        if self._thread is not None:
+            self._thread.join(timeout=2)
+
+    def running(self) -> bool:
+        return self._thread is not None and self._thread.is_alive()
+
+    def allocated_mb(self) -> float:
+        with self._lock:
+            return self._allocated_bytes / _MB
+
+    def _run(self, interval: float) -> None:
+        chunk_size = max(1, int(self._mb_per_sec * interval * _MB))
+        while not self._stop.wait(interval):
+            # Filled rather than zeroed so the pages are actually committed
+            chunk = b"\xa5" * chunk_size
+            with self._lock:
+                self._chunks.append(chunk)
+                self._allocated_bytes += chunk_size
+
+
+memory_leak = _MemoryLeak()
+
+
+def get_memory_leak_rate() -> float:
+    raw = os.getenv("MEMORY_LEAK_MB_PER_SEC")
+    if not raw:
+        return 0.0
+    try:
+        return max(0.0, float(raw))
+    except ValueError:
+        log_event("WARN", f"invalid MEMORY_LEAK_MB_PER_SEC={raw!r}, not leaking")
+        return 0.0
+
+
+_PLAIN_SEGMENT = re.compile(r"[A-Za-z0-9_.:-]+")
+
+
+def _grouping_segment(name: str, value: str) -> str:
+    """A Pushgateway grouping-key path pair, base64url-encoding values that are not path-safe."""
+    if _PLAIN_SEGMENT.fullmatch(value):
+        return f"{name}/{value}"
+    return f"{name}@base64/{base64.urlsafe_b64encode(value.encode()).decode()}"
+
+
+class MetricsPusher:
+    """Pushes the /metrics text to a Prometheus Pushgateway every interval seconds, and once more on stop.
+
+    For short-lived jobs that exit before Prometheus scrapes them. The job is PUSH_JOB
+    (default alert-example) and the common metric labels form the grouping key.
+    """
+
+    TIMEOUT_SECONDS = 5.0
+
+    def __init__(self, url: str, interval: float) -> None:
+        self._url = url.rstrip("/")
+        self._interval = interval
+        self._stop = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+
+    def push_url(self) -> str:
+        segments = [_grouping_segment("job", os.getenv("PUSH_JOB") or "alert-example")]
+        segments += [_grouping_segment(name, value) for name, value in metric_labels().items()]
+        return f"{self._url}/metrics/" + "/".join(segments)
+
+    def push(self) -> bool:
+        """PUT the current metrics, replacing this group's previous push; False on failure."""
+        try:
+            resp = requests.put(
+                self.push_url(),
+                data=metrics.render().encode(),
+                headers={"Content-Type": "text/plain; version=0.0.4"},
+                timeout=self.TIMEOUT_SECONDS,
+            )
+        except requests.RequestException as e:
+            log_event("WARN", f"metrics push failed: {e}", pushgateway_url=self._url)
+            return False
+        if resp.status_code >= 300:
+            log_event("WARN", f"pushgateway returned {resp.status_code}", pushgateway_url=self._url)
+            return False
+        return True
+
+    def start(self) -> None:
+        self._stop.clear()
+        self._thread = threading.Thread(target=self._run, name="metrics-pusher", daemon=True)
+        self._thread.start()
+        log_event("INFO", "pushing metrics", pushgateway_url=self._url, interval_seconds=self._interval)
+
+    def stop(self) -> None:
+        """Stop the periodic pushes, then push the final values."""
+        self._stop.set()
+        if self._thread is not None:
+            self._thread.join(timeout=self.TIMEOUT_SECONDS + 1)
+        self.push()
+
+    def running(self) -> bool:
+        return self._thread is not None and self._thread.is_alive()
+
+    def _run(self) -> None:
+        while not self._stop.wait(self._interval):
+            self.push()
+
+
+def get_push_interval_seconds() -> float:
+    interval = _env_float("PUSH_INTERVAL_SECONDS", 10.0)
+    if interval <= 0:
+        log_event("WARN", "PUSH_INTERVAL_SECONDS must be positive, using 10")
+        return 10.0
+    return interval
+
+
+def parse_port(raw: str, source: str = "PORT") -> int:
+    """Parse a TCP port, raising ValueError with a readable message unless it is 1-65535."""
+    try:
+        port = int(raw)
+    except ValueError:
+        raise ValueError(f"invalid {source} {raw!r}: must be a number between 1 and 65535") from None
+    if not 1 <= port <= 65535:
+        raise ValueError(f"invalid {source} {raw!r}: must be between 1 and 65535")
+    return port
+
+
+def get_port() -> int:
+    """Listen port from PORT (default 8080); raises ValueError when it is not a valid port."""
+    raw = os.getenv("PORT", "").strip()
+    if not raw:
+        return 8080
+    return parse_port(raw)
+
+
+def get_shutdown_grace_seconds() -> int:
+    raw = os.getenv("SHUTDOWN_GRACE_SECONDS")
+    if not raw:
+        return 10
+    try:
+        value = int(raw)
+        if value >= 0:
+            return value
+    except ValueError:
+        pass
+    log_event("WARN", f"invalid SHUTDOWN_GRACE_SECONDS={raw!r}, using 10")
+    return 10
+
+
+class _TrackedConnections(set):
+    """uvicorn's ServerState.connections, reporting each connection's open and close to active_connections.
+
+    uvicorn's protocols add themselves on connection_made and discard themselves on connection_lost.
+    """
+
+    def close_client(self, client) -> bool:
+        """Close the transport of the connection from client, a scope's (host, port); False if none matches."""
+        if not client:
+            return False
+        for conn in list(self):
+            if getattr(conn, "client", None) == tuple(client):
+                conn.transport.close()
+                return True
+        return False
+
+    def add(self, conn) -> None:
+        if conn not in self:
+            super().add(conn)
+            active_connections.opened()
+
+    def discard(self, conn) -> None:
+        if conn in self:
+            super().discard(conn)
+            active_connections.closed()
+
+
+class _Server(uvicorn.Server):
+    """uvicorn server whose signal handling is owned by main() rather than uvicorn.
+
+    With a cert_reloader, rotated TLS certificates are picked up while serving.
+    """
+
+    def __init__(self, config: uvicorn.Config, cert_reloader=None, connections=None) -> None:
+        super().__init__(config)
+        self.server_state.connections = _TrackedConnections() if connections is None else connections
+        self.cert_reloader = cert_reloader
+
+    async def serve(self, sockets=None) -> None:
+        if self.cert_reloader is None:
+            await super().serve(sockets)
+            return
+        if not self.config.loaded:
+            self.config.load()
+        self.cert_reloader.install(self.config.ssl)
+        self.cert_reloader.start()
+        try:
+            await super().serve(sockets)
+        finally:
+            self.cert_reloader.stop()
+
+    def install_signal_handlers(self) -> None:
+        pass
+
+    @contextlib.contextmanager
+    def capture_signals(self):
+        yield
+
+
+@dataclass(frozen=True)
+class ServerTimeouts:
+    """Connection timeouts in seconds, mirroring Go's http.Server fields."""
+
+    read_header: float = 5.0
+    read: float = 30.0
+    write: float = 30.0
+    idle: float = 60.0
+
+
+def get_server_timeouts() -> ServerTimeouts:
+    defaults = ServerTimeouts()
+    return ServerTimeouts(
+        read_header=_env_float("READ_HEADER_TIMEOUT_SECONDS", defaults.read_header),
+        read=_env_float("READ_TIMEOUT_SECONDS", defaults.read),
+        write=_env_float("WRITE_TIMEOUT_SECONDS", defaults.write),
+        idle=_env_float("IDLE_TIMEOUT_SECONDS", defaults.idle),
+    )
+
+
+# Go's http.DefaultMaxHeaderBytes
+DEFAULT_MAX_HEADER_BYTES = 1 << 20
+
+
+def get_max_header_bytes() -> int:
+    return _env_int("MAX_HEADER_BYTES", DEFAULT_MAX_HEADER_BYTES) or DEFAULT_MAX_HEADER_BYTES
+
+
+def request_header_bytes(scope) -> int:
+    """Size of the request line and header fields as sent on the wire."""
+    target = len(scope.get("raw_path") or scope["path"].encode())
+    if scope.get("query_string"):
+        target += 1 + len(scope["query_string"])
+    line = len(scope["method"]) + 1 + target + len(" HTTP/1.1\r\n")
+    return line + sum(len(name) + len(value) + 4 for name, value in scope["headers"])
+
+
+class _HeaderLimitMiddleware:
+    """ASGI wrapper answering 431 when the request line and headers exceed MAX_HEADER_BYTES.
+
+    The HTTP parser's own limit is set well above this one, so oversized headers reach
+    here and get a 431 rather than the parser's generic 400.
+    """
+
+    def __init__(self, asgi_app, limit: int) -> None:
+        self.app = asgi_app
+        self.limit = limit
+
+    async def __call__(self, scope, receive, send) -> None:
+        if scope["type"] != "http" or request_header_bytes(scope) <= self.limit:
+            await self.app(scope, receive, send)
+            return
+        log_event("WARN", "request headers too large", path=scope["path"], status=431, limit=self.limit)
+        response = Response(content="request header fields too large", status_code=431, headers={"Connection": "close"})
+        await response(scope, receive, send)
+
+
+class _TimeoutMiddleware:
+    """ASGI wrapper bounding each request-body read and each response write.
+
+    uvicorn has no read/write timeouts of its own. A body read that stalls is treated as
+    a client disconnect; a write that can't drain to a slow client fails the request.
+    Once the body is complete, receive() is left alone so disconnect listeners still work.
+    """
+
+    def __init__(self, asgi_app, read_timeout: float, write_timeout: float) -> None:
+        self.app = asgi_app
+        self.read_timeout = read_timeout
+        self.write_timeout = write_timeout
+
+    async def __call__(self, scope, receive, send) -> None:
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+        body_done = False
+
+        async def timed_receive():
+            nonlocal body_done
+            if body_done or self.read_timeout <= 0:
+                return await receive()
+            try:
+                message = await asyncio.wait_for(receive(), self.read_timeout)
+            except asyncio.TimeoutError:
+                log_event("WARN", "request body read timed out", path=scope["path"], timeout=self.read_timeout)
+                return {"type": "http.disconnect"}
+            body_done = message["type"] != "http.request" or not message.get("more_body", False)
+            return message
+
+        async def timed_send(message) -> None:
+            if self.write_timeout <= 0:
+                await send(message)
+                return
+            try:
+                await asyncio.wait_for(send(message), self.write_timeout)
+            except asyncio.TimeoutError:
+                log_event("WARN", "response write timed out", path=scope["path"], timeout=self.write_timeout)
+                raise
+
+        await self.app(scope, timed_receive, timed_send)
+
+
+class _CloseConnectionMiddleware:
+    """ASGI wrapper for DISABLE_KEEPALIVE: marks every response Connection: close.
+
+    uvicorn has no switch to turn keep-alive off, but it closes the connection after
+    any response carrying that header, so no connection is ever reused.
+    """
+
+    def __init__(self, asgi_app) -> None:
+        self.app = asgi_app
+
+    async def __call__(self, scope, receive, send) -> None:
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        async def closing_send(message) -> None:
+            if message["type"] == "http.response.start":
+                headers = [(k, v) for k, v in message.get("headers", []) if k.lower() != b"connection"]
+                message = {**message, "headers": headers + [(b"connection", b"close")]}
+            await send(message)
+
+        await self.app(scope, receive, closing_send)
+
+
+def _is_response_cut(exc: BaseException) -> bool:
+    """ResponseCut, alone or as all of an exception group from the task groups it passed through."""
+    if isinstance(exc, BaseExceptionGroup):
+        return all(_is_response_cut(e) for e in exc.exceptions)
+    return isinstance(exc, ResponseCut)
+
+
+class _ResponseCutMiddleware:
+    """ASGI wrapper that ends a response cut short by ResponseCut without the server seeing the exception.
+
+    With connections (uvicorn's), the client's transport is closed once the partial body is
+    flushed and the disconnect awaited, so uvicorn doesn't report the unfinished response
+    either. Without, returning is enough for hypercorn to close the stream.
+    """
+
+    # Bounds the wait for the closed transport to be reported, should the client not read
+    DISCONNECT_WAIT_SECONDS = 5.0
+
+    def __init__(self, asgi_app, connections: Optional[_TrackedConnections] = None) -> None:
+        self.app = asgi_app
+        self.connections = connections
+
+    async def __call__(self, scope, receive, send) -> None:
+        try:
+            await self.app(scope, receive, send)
+        except Exception as exc:
+            if not _is_response_cut(exc):
+                raise
+            if self.connections is None or not self.connections.close_client(scope.get("client")):
+                return
+
+            async def disconnected() -> None:
+                while (await receive())["type"] != "http.disconnect":
+                    pass
+
+            with contextlib.suppress(asyncio.TimeoutError):
+                await asyncio.wait_for(disconnected(), self.DISCONNECT_WAIT_SECONDS)
+
+
+class _H2CServer:
+    """Hypercorn server for ENABLE_H2C: accepts cleartext HTTP/2 alongside HTTP/1.1.
+
+    uvicorn only speaks HTTP/1.1, so this stands in for _Server with the same
+    should_exit/serve() surface used by run_server and request_shutdown, and the same
+    DISABLE_KEEPALIVE and TLS certificate reload behavior. Open connections can't be
+    counted through hypercorn, which is warned about when it is built.
+    """
+
+    def __init__(
+        self,
+        host: str,
+        port: int,
+        tls_files: Optional[tuple] = None,
+        asgi_app=None,
+        timeouts: Optional[ServerTimeouts] = None,
+    ) -> None:
+        # Only needed for h2c, so the default HTTP/1.1 path doesn't depend on it
+        from hypercorn.config import Config
+
+        timeouts = timeouts or get_server_timeouts()
+        self.config = Config()
+        self.config.bind = [f"[{host}]:{port}" if ":" in host else f"{host}:{port}"]
+        self.config.graceful_timeout = float(get_shutdown_grace_seconds())
+        self.config.keep_alive_timeout = timeouts.idle
+        # Hypercorn's socket read timeout also bounds how long a client may take to send headers
+        self.config.read_timeout = timeouts.read_header or None
+        if _env_flag("DISABLE_KEEPALIVE"):
+            # A Connection: close header is illegal in HTTP/2, so cap each connection at one request instead
+            self.config.keep_alive_max_requests = 1
+        self.cert_reloader = None
+        if tls_files:
+            self.config.certfile, self.config.keyfile = tls_files
+            self.cert_reloader = _CertReloader(*tls_files, alpn_protocols=self.config.alpn_protocols)
+            create_ssl_context = self.config.create_ssl_context
+
+            def reloading_ssl_context():
+                context = create_ssl_context()
+                self.cert_reloader.install(context)
+                return context
+
+            self.config.create_ssl_context = reloading_ssl_context
+        max_header_bytes = get_max_header_bytes()
+        self.config.h11_max_incomplete_size = 2 * max_header_bytes
+        self.config.h2_max_header_list_size = 2 * max_header_bytes
+        log_event("WARN", "alert_example_active_connections is not tracked with ENABLE_H2C", port=port)
+        if _json_logs():
+            # Hypercorn's access log stays off unless configured, so only its error log is reformatted
+            self.config.logconfig_dict = server_log_config("hypercorn.error")
+        self.should_exit = False
+        self._app = _ResponseCutMiddleware(
+            _HeaderLimitMiddleware(_TimeoutMiddleware(asgi_app or app, timeouts.read, timeouts.write), max_header_bytes)
+        )
+
+    async def _shutdown_trigger(self) -> None:
+        while not self.should_exit:
+            await asyncio.sleep(0.1)
+
+    async def serve(self) -> None:
+        from hypercorn.asyncio import serve
+
+        if self.cert_reloader is None:
+            await serve(self._app, self.config, shutdown_trigger=self._shutdown_trigger)
+            return
+        self.cert_reloader.start()
+        try:
+            await serve(self._app, self.config, shutdown_trigger=self._shutdown_trigger)
+        finally:
+            self.cert_reloader.stop()
+
+
+# Served only on ADMIN_PORT when it is set, keeping probes and controls off the traffic port
+ADMIN_PATHS = {
+    "/healthz",
+    "/readyz",
+    "/metrics",
+    "/metrics/reset",
+    "/version",
+    "/env",
+    "/config/reload",
+    "/leak/status",
+    "/leak/stop",
+}
+ADMIN_PREFIXES = ("/admin/", "/debug/pprof/")
+
+
+def is_admin_path(path: str) -> bool:
+    return path in ADMIN_PATHS or path.startswith(ADMIN_PREFIXES)
+
+
+class _PathFilter:
+    """ASGI wrapper exposing only the admin routes (admin=True) or only the rest (admin=False)."""
+
+    def __init__(self, asgi_app, admin: bool) -> None:
+        self._app = asgi_app
+        self._admin = admin
+
+    async def __call__(self, scope, receive, send) -> None:
+        if scope["type"] == "http" and is_admin_path(scope["path"]) != self._admin:
+            response = Response(content="not found", status_code=404, media_type="text/plain; charset=utf-8")
+            await response(scope, receive, send)
+            return
+        await self._app(scope, receive, send)
+
+
+def get_tcp_health_port() -> Optional[int]:
+    """TCP_HEALTH_PORT as a port number, or None when unset; raises ValueError when invalid."""
+    raw = os.getenv("TCP_HEALTH_PORT", "").strip()
+    return parse_port(raw, source="TCP_HEALTH_PORT") if raw else None
+
+
+def get_admin_port() -> Optional[int]:
+    """ADMIN_PORT as a port number, or None when unset; raises ValueError when invalid."""
+    raw = os.getenv("ADMIN_PORT", "").strip()
+    return parse_port(raw, source="ADMIN_PORT") if raw else None
+
+
+def parse_args(argv: Optional[list] = None) -> argparse.Namespace:
+    parser = argparse.ArgumentParser(description="alert-example fault-injection app")
+    parser.add_argument("--addr", help="listen address, e.g. :9090 or 127.0.0.1:8080 (overrides PORT)")
+    parser.add_argument("--config", help="config file path (overrides CONFIG_PATH)")
+    parser.add_argument(
+        "--check", action="store_true", help="validate the config and exit without serving (0 clean, non-zero if not)"
+    )
+    return parser.parse_args(argv)
+
+
+def resolve_listen_address(addr: Optional[str]) -> tuple:
+    """Return (host, port) from an --addr value, or all interfaces on PORT when unset."""
+    if not addr:
+        return "0.0.0.0", get_port()
+    host, _, port = addr.rpartition(":")
+    return host.strip("[]") or "0.0.0.0", parse_port(port, source="--addr port")
+
+
+def resolve_flags(argv: Optional[list] = None) -> tuple:
+    """Return (host, port, config_path), with flags taking precedence over env vars."""
+    args = parse_args(argv)
+    host, port = resolve_listen_address(args.addr)
+    return host, port, args.config or get_config_path()
+
+
+def get_tls_files() -> Optional[tuple]:
+    """Return (cert_file, key_file) when TLS is configured, or None for plain HTTP.
+
+    Raises ValueError when only one of TLS_CERT_FILE and TLS_KEY_FILE is set.
+    """
+    cert_file = os.getenv("TLS_CERT_FILE")
+    key_file = os.getenv("TLS_KEY_FILE")
+    if not cert_file and not key_file:
+        return None
+    if not cert_file or not key_file:
+        raise ValueError("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+    return cert_file, key_file
+
+
+class _CertReloader:
+    """Keeps the TLS certificate current without a restart, like Go's tls.Config.GetCertificate.
+
+    A watcher re-reads the cert and key when either file changes; new handshakes then
+    use the new pair. A pair that fails to load is logged and the previous one kept.
+    """
+
+    def __init__(self, cert_file: str, key_file: str, alpn_protocols: Optional[list] = None) -> None:
+        self.cert_file = cert_file
+        self.key_file = key_file
+        # Carried over to each reloaded context, as the swapped-in context also does the ALPN negotiation
+        self.alpn_protocols = alpn_protocols
+        self._lock = threading.Lock()
+        self._context = self._load()
+        self._watcher = ConfigWatcher(self.reload, paths=lambda: [cert_file, key_file])
+
+    def _load(self) -> ssl.SSLContext:
+        context = ssl.SSLContext(ssl.PROTOCOL_TLS_SERVER)
+        context.load_cert_chain(self.cert_file, self.key_file)
+        if self.alpn_protocols:
+            context.set_alpn_protocols(self.alpn_protocols)
+        return context
+
+    def reload(self) -> bool:
+        try:
+            context = self._load()
+        except (OSError, ssl.SSLError) as e:
+            log_event(
+                "ERROR", f"failed to reload TLS certificate, keeping the previous one: {e}", cert_file=self.cert_file
+            )
+            return False
+        with self._lock:
+            self._context = context
+        log_event("INFO", "TLS certificate reloaded", cert_file=self.cert_file)
+        return True
+
+    def sni_callback(self, ssl_object, server_name, context) -> None:
+        # Called for every handshake, with or without SNI, before the certificate is chosen
+        with self._lock:
+            ssl_object.context = self._context
+
+    def install(self, context: ssl.SSLContext) -> None:
+        context.sni_callback = self.sni_callback
+
+    def start(self) -> None:
+        self._watcher.start()
+
+    def stop(self) -> None:
+        self._watcher.stop()
+
+
+class _JSONLogFormatter(logging.Formatter):
+    """Renders the HTTP server's own log records in the LOG_FORMAT=json shape of log_event."""
+
+    LEVELS = {"WARNING": "WARN", "CRITICAL": "ERROR"}
+
+    def format(self, record: logging.LogRecord) -> str:
+        ts = datetime.fromtimestamp(record.created, timezone.utc).isoformat(timespec="seconds").replace("+00:00", "Z")
+        fields = {"logger": record.name}
+        if record.exc_info:
+            fields["stack"] = self.formatException(record.exc_info)
+        event = {"ts": ts, "level": self.LEVELS.get(record.levelname, record.levelname), "msg": record.getMessage()}
+        return json.dumps({**event, **fields}, default=str)
+
+
+def server_log_config(error_logger: str, access_logger: Optional[str] = None) -> dict:
+    """logging.config.dictConfig for the server's loggers, rendering them with _JSONLogFormatter.
+
+    As with uvicorn's defaults, errors and lifecycle lines go to stderr and access lines to stdout.
+    """
+    config = {
+        "version": 1,
+        "disable_existing_loggers": False,
+        "formatters": {"json": {"()": _JSONLogFormatter}},
+        "handlers": {"default": {"class": "logging.StreamHandler", "formatter": "json", "stream": "ext://sys.stderr"}},
+        "loggers": {error_logger: {"handlers": ["default"], "level": "INFO", "propagate": False}},
+    }
+    if access_logger:
+        config["handlers"]["access"] = {**config["handlers"]["default"], "stream": "ext://sys.stdout"}
+        config["loggers"][access_logger] = {"handlers": ["access"], "level": "INFO", "propagate": False}
+    return config
+
+
+def build_server(
+    host: str = "0.0.0.0",
+    port: Optional[int] = None,
+    tls_files: Optional[tuple] = None,
+    asgi_app=None,
+    timeouts: Optional[ServerTimeouts] = None,
+) -> _Server:
+    """Build the server from resolved settings; timeouts default to the *_TIMEOUT_SECONDS env vars.
+
+    Request headers are limited to MAX_HEADER_BYTES (default 1 MiB).
+    """
+    timeouts = timeouts or get_server_timeouts()
+    if _env_flag("ENABLE_H2C"):
+        return _H2CServer(host, get_port() if port is None else port, tls_files, asgi_app, timeouts)
+    cert_file, key_file = tls_files or (None, None)
+    max_header_bytes = get_max_header_bytes()
+    asgi_app = _HeaderLimitMiddleware(
+        _TimeoutMiddleware(asgi_app or app, timeouts.read, timeouts.write), max_header_bytes
+    )
+    if _env_flag("DISABLE_KEEPALIVE"):
+        asgi_app = _CloseConnectionMiddleware(asgi_app)
+    connections = _TrackedConnections()
+    asgi_app = _ResponseCutMiddleware(asgi_app, connections)
+    config = uvicorn.Config(
+        asgi_app,
+        host=host,
+        port=get_port() if port is None else port,
+        timeout_graceful_shutdown=get_shutdown_grace_seconds(),
+        timeout_keep_alive=timeouts.idle,
+        h11_max_incomplete_event_size=2 * max_header_bytes,
+        ssl_certfile=cert_file,
+        ssl_keyfile=key_file,
+        log_config=server_log_config("uvicorn", "uvicorn.access") if _json_logs() else uvicorn.config.LOGGING_CONFIG,
+    )
+    return _Server(config, _CertReloader(cert_file, key_file) if tls_files else None, connections)
+
+
+class _TCPHealthServer:
+    """Listener on TCP_HEALTH_PORT that accepts each connection and closes it at once, for tcpSocket probes.
+
+    It reports that the process is alive independently of the HTTP servers, and has the
+    should_exit/serve() surface of _Server so it starts and stops along with them.
+    """
+
+    def __init__(self, host: str, port: int) -> None:
+        self.host = host
+        self.port = port
+        self.should_exit = False
+        self.started = False
+
+    @staticmethod
+    async def _accept(reader: asyncio.StreamReader, writer: asyncio.StreamWriter) -> None:
+        writer.close()
+
+    async def serve(self) -> None:
+        listener = await asyncio.start_server(self._accept, self.host, self.port)
+        self.started = True
+        log_event("INFO", "tcp health listener started", port=self.port)
+        try:
+            while not self.should_exit:
+                await asyncio.sleep(0.1)
+        finally:
+            listener.close()
+            await listener.wait_closed()
+            self.started = False
+
+
+def build_servers(
+    host: str,
+    port: int,
+    tls_files: Optional[tuple],
+    admin_port: Optional[int] = None,
+    tcp_health_port: Optional[int] = None,
+) -> list:
+    """The traffic server, plus an admin server on admin_port that takes over the admin routes
+    and a TCP health listener on tcp_health_port, each only when its port is given.
+    """
+    if admin_port is None:
+        servers = [build_server(host, port, tls_files)]
+    else:
+        servers = [
+            build_server(host, port, tls_files, asgi_app=_PathFilter(app, admin=False)),
+            build_server(host, admin_port, tls_files, asgi_app=_PathFilter(app, admin=True)),
+        ]
+    if tcp_health_port is not None:
+        servers.append(_TCPHealthServer(host, tcp_health_port))
+    return servers
+
+
+def request_shutdown(server: _Server, signum: int) -> None:
+    """Stop accepting connections and drain in-flight requests within the grace period."""
+    log_event(
+        "INFO",
+        f"received {signal.Signals(signum).name}, draining in-flight requests",
+        grace_seconds=get_shutdown_grace_seconds(),
+    )
+    server.should_exit = True
+
+
+def get_startup_delay_seconds() -> float:
+    raw = os.getenv("STARTUP_DELAY_SECONDS")
+    if not raw:
+        return 0.0
+    try:
+        return max(0.0, float(raw))
+    except ValueError:
+        log_event("WARN", f"invalid STARTUP_DELAY_SECONDS={raw!r}, not delaying")
+        return 0.0
+
+
+async def _startup_delay(server: _Server, seconds: float) -> bool:
+    """Wait before listening, logging once a second; False if shutdown was requested meanwhile."""
+    loop = asyncio.get_running_loop()
+    deadline = loop.time() + seconds
+    next_log = loop.time()
+    while True:
+        if server.should_exit:
+            log_event("INFO", "shutdown requested during startup delay")
+            return False
+        now = loop.time()
+        if now >= deadline:
+            return True
+        if now >= next_log:
+            log_event("INFO", "delaying startup", remaining_seconds=round(deadline - now, 1))
+            next_log = now + 1.0
+        await asyncio.sleep(min(0.1, deadline - now))
+
+
+async def run_server(server: _Server) -> None:
+    if await _startup_delay(server, get_startup_delay_seconds()):
+        await server.serve()
+
+
+def _request_shutdown_all(servers: tuple, signum: int) -> None:
+    for server in servers:
+        request_shutdown(server, signum)
+
+
+class _ShutdownSignals:
+    """Counts SIGTERM/SIGINT: the first starts a graceful shutdown, any later one forces an exit."""
+
+    def __init__(self) -> None:
+        self._lock = threading.Lock()
+        self._received = 0
+        self._forced = threading.Event()
+
+    def receive(self) -> int:
+        """Record a signal and return how many have arrived."""
+        with self._lock:
+            self._received += 1
+            return self._received
+
+    def force(self) -> None:
+        self._forced.set()
+
+    def forced(self) -> bool:
+        return self._forced.is_set()
+
+
+shutdown_signals = _ShutdownSignals()
+
+
+def force_shutdown(servers: tuple, signum: int) -> None:
+    """Exit at once, cutting short the pre-stop delay, the drain and any SHUTDOWN_HANG_SECONDS."""
+    log_event("WARN", f"received {signal.Signals(signum).name} again, exiting immediately")
+    shutdown_signals.force()
+    for server in servers:
+        server.force_exit = True
+    exit_func(1)
+
+
+def begin_shutdown(servers: tuple, signum: int) -> None:
+    """SIGTERM/SIGINT handler: fail readiness now, drain after PRESTOP_DELAY_SECONDS (default 0).
+
+    The delay keeps /config serving while endpoint removal propagates, like a preStop sleep.
+    A second signal exits immediately.
+    """
+    if shutdown_signals.receive() > 1:
+        force_shutdown(servers, signum)
+        return
+    readiness.set_draining(True)
+    delay = _env_float("PRESTOP_DELAY_SECONDS")
+    if delay <= 0:
+        _request_shutdown_all(servers, signum)
+        return
+    log_event(
+        "INFO",
+        f"received {signal.Signals(signum).name}, failing readiness and serving until the pre-stop delay ends",
+        prestop_delay_seconds=delay,
+    )
+    timer = threading.Timer(delay, _request_shutdown_all, args=(servers, signum))
+    timer.daemon = True
+    timer.start()
+
+
+async def hang_shutdown(seconds: float) -> None:
+    """Stay alive for SHUTDOWN_HANG_SECONDS after draining, like a stuck shutdown hook, so only SIGKILL ends it.
+
+    Not bounded by SHUTDOWN_GRACE_SECONDS; a second SIGTERM/SIGINT still exits at once.
+    """
+    if seconds <= 0:
+        return
+    log_event("WARN", "simulating a hung shutdown", hang_seconds=seconds)
+    loop = asyncio.get_running_loop()
+    deadline = loop.time() + seconds
+    while not shutdown_signals.forced():
+        remaining = deadline - loop.time()
+        if remaining <= 0:
+            return
+        await asyncio.sleep(min(0.05, remaining))
+
+
+# The servers _serve is running, for shutdowns that don't arrive as signals
+running_servers: tuple = ()
+
+
+async def _serve(*servers: _Server) -> None:
+    """Run every server until a SIGTERM/SIGINT drains them all together."""
+    global running_servers
+    loop = asyncio.get_running_loop()
+    for sig in (signal.SIGTERM, signal.SIGINT):
+        loop.add_signal_handler(sig, begin_shutdown, servers, sig)
+    loop.add_signal_handler(signal.SIGUSR1, toggle_drain, signal.SIGUSR1)
+    running_servers = servers
+    try:
+        await asyncio.gather(*(run_server(server) for server in servers))
+        await hang_shutdown(_env_float("SHUTDOWN_HANG_SECONDS"))
+    finally:
+        running_servers = ()
+
+
+def _shutdown_running_servers() -> None:
+    for server in running_servers:
+        server.should_exit = True
+
+
+@app.post("/admin/shutdown")
+def handle_shutdown(request: Request) -> Response:
+    """Drain and stop every server as SIGTERM would; main() then returns and the process exits 0.
+
+    Enabled by ALLOW_REMOTE_SHUTDOWN=true or by configured basic auth, whose credentials are then required.
+    """
+    if not _env_flag("ALLOW_REMOTE_SHUTDOWN") and get_basic_auth() is None:
+        return Response(content="remote shutdown is disabled", status_code=404)
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    grace = get_shutdown_grace_seconds()
+    log_event("INFO", "shutdown requested, draining in-flight requests", path="/admin/shutdown", grace_seconds=grace)
+    # Stop only after the 202 has been written, so the caller gets its answer
+    return JSONResponse(
+        {"shutting_down": True, "grace_seconds": grace},
+        status_code=202,
+        background=BackgroundTask(_shutdown_running_servers),
+    )
+
+
+@app.post("/admin/crash")
+def handle_crash(request: Request) -> Response:
+    """Exit with CRASH_EXIT_CODE after CRASH_DELAY_MS, whatever the config says.
+
+    Enabled by ALLOW_REMOTE_CRASH=true; configured basic auth credentials are also required.
+    """
+    if not _env_flag("ALLOW_REMOTE_CRASH"):
+        return Response(content="remote crash is disabled", status_code=404)
+    denied = require_basic_auth(request)
+    if denied is not None:
+        return denied
+    exit_code = get_crash_exit_code()
+    delay_ms = _env_int("CRASH_DELAY_MS", 500)
+    log_event(
+        "WARN",
+        "crash requested, terminating",
+        path="/admin/crash",
+        client=client_ip(request),
+        user_agent=request.headers.get("user-agent", "-"),
+        exit_code=exit_code,
+    )
+    return JSONResponse(
+        {"crashing": True, "exit_code": exit_code, "delay_ms": delay_ms},
+        status_code=202,
+        background=BackgroundTask(schedule_crash_exit),
+    )
+
+
+def effective_settings(host: str, port: int, tls_files: Optional[tuple]) -> dict:
+    """Effective env-derived settings for the startup event and /env; secrets are redacted."""
+    return {
+        "version": version,
+        "config_path": get_config_path(),
+        "listen_addr": f"{host}:{port}",
+        "admin_port": os.getenv("ADMIN_PORT") or None,
+        "tcp_health_port": os.getenv("TCP_HEALTH_PORT") or None,
+        "tls": tls_files is not None,
+        "tls_cert_file": os.getenv("TLS_CERT_FILE") or None,
+        "tls_key_file": "***" if os.getenv("TLS_KEY_FILE") else None,
+        "h2c": _env_flag("ENABLE_H2C"),
+        "max_header_bytes": get_max_header_bytes(),
+        "disable_keepalive": _env_flag("DISABLE_KEEPALIVE"),
+        "scenario": get_scenario() or None,
+        "crash_keyword": ",".join(get_crash_keywords()),
+        "crash_armed": crash_armed_by_scenario(),
+        "crash_mode": get_crash_mode(),
+        "crash_exit_code": get_crash_exit_code(),
+        "crash_delay_ms": _env_int("CRASH_DELAY_MS", 500),
+        "crash_after_seconds": _env_float("CRASH_AFTER_SECONDS"),
+        "crash_jitter_seconds": _env_float("CRASH_JITTER_SECONDS"),
+        "error_rate": get_error_rate(),
+        "response_delay_ms": get_response_delay_ms(),
+        "latency_distribution": os.getenv("LATENCY_DISTRIBUTION") or None,
+        "default_status": get_default_status(),
+        "status_sequence": get_status_sequence() or None,
+        "require_config": _env_flag("REQUIRE_CONFIG", default=True),
+        "fail_startup_read": _env_flag("FAIL_STARTUP_READ"),
+        "config_cache_ttl_ms": _env_int("CONFIG_CACHE_TTL_MS"),
+        "watch_config": _env_flag("WATCH_CONFIG"),
+        "validate_yaml": _env_flag("VALIDATE_YAML"),
+        "response_headers": os.getenv("RESPONSE_HEADERS") or None,
+        "recover_panics": _env_flag("RECOVER_PANICS", default=True),
+        "detailed_health": _env_flag("DETAILED_HEALTH"),
+        "access_log": _env_flag("ACCESS_LOG"),
+        "log_request_body": _env_flag("LOG_REQUEST_BODY"),
+        "log_response_body": _env_flag("LOG_RESPONSE_BODY"),
+        "allow_metrics_reset": _env_flag("ALLOW_METRICS_RESET"),
+        "basic_auth_user": os.getenv("BASIC_AUTH_USER") or None,
+        "basic_auth_pass": "***" if os.getenv("BASIC_AUTH_PASS") else None,
+        "rate_limit_rps": _env_float("RATE_LIMIT_RPS"),
+        "memory_leak_mb_per_sec": get_memory_leak_rate(),
+        "pushgateway_url": os.getenv("PUSHGATEWAY_URL") or None,
+        "push_interval_seconds": get_push_interval_seconds(),
+        "ready_file": os.getenv("READY_FILE") or None,
+        "dependency_url": os.getenv("DEPENDENCY_URL") or None,
+        "cb_failure_threshold": _env_int("CB_FAILURE_THRESHOLD"),
+        "forward_url": os.getenv("FORWARD_URL") or None,
+        "startup_delay_seconds": get_startup_delay_seconds(),
+        "startup_failure_rate": get_startup_failure_rate(),
+        "shutdown_grace_seconds": get_shutdown_grace_seconds(),
+        "prestop_delay_seconds": _env_float("PRESTOP_DELAY_SECONDS"),
+        "shutdown_hang_seconds": _env_float("SHUTDOWN_HANG_SECONDS"),
+        "allow_remote_shutdown": _env_flag("ALLOW_REMOTE_SHUTDOWN"),
+        "allow_remote_crash": _env_flag("ALLOW_REMOTE_CRASH"),
+    }
+
+
+@app.get("/env")
+def handle_env(request: Request) -> Response:
+    if not _env_flag("EXPOSE_ENV"):
+        return Response(content="env endpoint is disabled", status_code=404)
+    host, port = request.scope.get("server") or ("0.0.0.0", get_port())
+    try:
+        tls_files = get_tls_files()
+    except ValueError:
+        tls_files = None
+    return JSONResponse(effective_settings(host, port, tls_files))
+
+
+CHECK_OK = 0
+CHECK_INVALID = 1
+CHECK_CRASH_ARMED = 2
+
+
+def check_config() -> int:
+    """Read and validate the config for --check, print the verdict and return the exit code.
+
+    YAML is validated only under VALIDATE_YAML, as when serving.
+    """
+    path = get_config_path()
+    try:
+        data = read_config()
+    except Exception as e:
+        print(f"config check failed: cannot read {path}: {e}", file=sys.stderr)
+        return CHECK_INVALID
+    if _env_flag("VALIDATE_YAML"):
+        error = yaml_error(data)
+        if error is not None:
+            print(f"config check failed: {path} is not valid YAML: {error}", file=sys.stderr)
+            return CHECK_INVALID
+    if should_crash(data):
+        reason = ",".join(matched_crash_keywords(data)) or f"scenario {get_scenario()}"
+        print(f"config check failed: {path} is crash-armed ({reason})", file=sys.stderr)
+        return CHECK_CRASH_ARMED
+    print(f"config check passed: {path}")
+    return CHECK_OK
+
+
+def main(argv: Optional[list] = None) -> None:
+    global config_path_override, config_watcher, response_headers
+    try:
+        host, port, config_path_override = resolve_flags(argv)
+        admin_port = get_admin_port()
+        tcp_health_port = get_tcp_health_port()
+        tls_files = get_tls_files()
+    except ValueError as e:
+        log_event("ERROR", str(e))
+        exit_func(1)
+        return
+    if parse_args(argv).check:
+        exit_func(check_config())
+        return
+    scenario = get_scenario()
+    if scenario and scenario not in SCENARIOS:
+        log_event("WARN", f"unknown SCENARIO={scenario!r}, using individual settings", known=",".join(SCENARIOS))
+    elif scenario:
+        log_event("INFO", "scenario selected", scenario=scenario)
+    log_event("INFO", "startup settings", **effective_settings(host, port, tls_files))
+    response_headers = parse_response_headers(os.getenv("RESPONSE_HEADERS", ""))
+    if should_fail_startup():
+        log_event("ERROR", "simulated startup failure, exiting", startup_failure_rate=get_startup_failure_rate())
+        exit_func(1)
+        return
+    arm_readiness_delay()
+    startup_check()
+    crash_timer = start_crash_timer()
+    if _env_flag("WATCH_CONFIG"):
+        config_watcher = ConfigWatcher(handle_config_change)
+        config_watcher.start()
+    leak_rate = get_memory_leak_rate()
+    if leak_rate > 0:
+        memory_leak.start(leak_rate)
+    pusher = None
+    if os.getenv("PUSHGATEWAY_URL"):
+        pusher = MetricsPusher(os.environ["PUSHGATEWAY_URL"], get_push_interval_seconds())
+        pusher.start()
+    servers = build_servers(host, port, tls_files, admin_port, tcp_health_port)
+    asyncio.run(_serve(*servers))
+    if crash_timer is not None:
+        crash_timer.cancel()
+    if config_watcher is not None:
+        config_watcher.stop()
+    if pusher is not None:
+        pusher.stop()
+    # A signal-initiated shutdown is a clean exit, not a failure
+    log_event("INFO", "server stopped")
 
 
+if __name__ == "__main__":
+    main()
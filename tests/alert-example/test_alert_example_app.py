@@ -0,0 +1,4897 @@
+"""Tests for the alert-example fault-injection app (app/app.py).
+
+The app ships its own requirements (app/requirements.txt), which the root test
+group does not install. `make test-alert-example` runs these tests with them and
+sets ALERT_EXAMPLE_REQUIRE_DEPS=1 so a missing package fails instead of skipping.
+"""
+
+import asyncio
+import concurrent.futures
+import hashlib
+import http.client
+import http.server
+import importlib.util
+import json
+import os
+import random
+import re
+import shutil
+import signal
+import socket
+import ssl
+import statistics
+import subprocess
+import threading
+import time
+import tracemalloc
+import uuid
+from pathlib import Path
+
+import pytest
+
+for _module in (
+    "fastapi",
+    "httpx",
+    "uvicorn",
+    "hypercorn",
+    "opentelemetry.sdk",
+    "opentelemetry.exporter.otlp.proto.http.trace_exporter",
+    "opentelemetry.instrumentation.fastapi",
+    "opentelemetry.instrumentation.requests",
+    "yaml",
+):
+    if os.getenv("ALERT_EXAMPLE_REQUIRE_DEPS") == "1":
+        importlib.import_module(_module)
+    else:
+        pytest.importorskip(_module, reason=f"{_module} not installed; run make test-alert-example")
+
+import httpx
+from fastapi.testclient import TestClient
+
+APP_PATH = Path(__file__).parent / "app" / "app.py"
+
+
+def _load_app():
+    spec = importlib.util.spec_from_file_location("alert_example_app", APP_PATH)
+    module = importlib.util.module_from_spec(spec)
+    spec.loader.exec_module(module)
+    return module
+
+
+alert_app = _load_app()
+
+
+@pytest.fixture(autouse=True)
+def fresh_state(monkeypatch):
+    """Give every test its own counters so assertions don't depend on test order"""
+    monkeypatch.setattr(alert_app, "metrics", alert_app._Metrics())
+    monkeypatch.setattr(alert_app, "readiness", alert_app._Readiness())
+    monkeypatch.setattr(alert_app, "config_path_override", None)
+    monkeypatch.setattr(alert_app, "health_flip", alert_app._HealthFlip())
+    monkeypatch.setattr(alert_app, "dependency", alert_app._DependencyCheck())
+    monkeypatch.setattr(alert_app, "rate_limiter", alert_app._RateLimiter())
+    monkeypatch.setattr(alert_app, "concurrency", alert_app._ConcurrencyLimiter())
+    monkeypatch.setattr(alert_app, "echo_history", alert_app._EchoHistory())
+    monkeypatch.setattr(alert_app, "response_headers", {})
+    monkeypatch.setattr(alert_app, "config_history", alert_app._ConfigHistory())
+    monkeypatch.setattr(alert_app, "status_sequence", alert_app._StatusSequence())
+    monkeypatch.setattr(alert_app, "config_cache", alert_app._ConfigCache())
+    monkeypatch.setattr(alert_app, "runtime_settings", alert_app._RuntimeSettings())
+    monkeypatch.setattr(alert_app, "shutdown_signals", alert_app._ShutdownSignals())
+    # Otherwise every /metrics series would carry the host's instance and pod labels
+    for name in ("HOSTNAME", "POD_NAME", "METRIC_LABELS"):
+        monkeypatch.delenv(name, raising=False)
+
+
+@pytest.fixture
+def config_file(tmp_path, monkeypatch):
+    """Point CONFIG_PATH at a healthy config file"""
+    path = tmp_path / "config.yaml"
+    path.write_text('message: "all good"\n')
+    monkeypatch.setenv("CONFIG_PATH", str(path))
+    return path
+
+
+@pytest.fixture
+def client():
+    # Not used as a context manager, so the startup crash check does not run
+    return TestClient(alert_app.app)
+
+
+def _free_port():
+    with socket.socket() as sock:
+        sock.bind(("127.0.0.1", 0))
+        return sock.getsockname()[1]
+
+
+def _start_server(server):
+    """Run a uvicorn server on a background thread and wait until it accepts connections"""
+    thread = threading.Thread(target=lambda: asyncio.run(server.serve()), daemon=True)
+    thread.start()
+    deadline = time.monotonic() + 5
+    while not server.started:
+        assert time.monotonic() < deadline, "server did not start"
+        time.sleep(0.01)
+    return thread
+
+
+class _Upstream:
+    """Local HTTP server standing in for an external service the app talks to"""
+
+    def __init__(self):
+        self.status = 200
+        self.statuses = []  # served first, one per request, before falling back to status
+        self.body = b"ok"
+        self.requests = []
+        upstream = self
+
+        class Handler(http.server.BaseHTTPRequestHandler):
+            def _respond(self):
+                length = int(self.headers.get("Content-Length") or 0)
+                upstream.requests.append((self.command, self.path, dict(self.headers), self.rfile.read(length)))
+                status = upstream.statuses.pop(0) if upstream.statuses else upstream.status
+                self.send_response(status)
+                self.send_header("Content-Length", str(len(upstream.body)))
+                self.end_headers()
+                self.wfile.write(upstream.body)
+
+            do_GET = do_POST = do_PUT = _respond
+
+            def log_message(self, *args):
+                pass
+
+        self._server = http.server.ThreadingHTTPServer(("127.0.0.1", 0), Handler)
+        self.url = f"http://127.0.0.1:{self._server.server_port}"
+        threading.Thread(target=self._server.serve_forever, daemon=True).start()
+
+    def close(self):
+        self._server.shutdown()
+        self._server.server_close()
+
+
+@pytest.fixture
+def upstream():
+    server = _Upstream()
+    yield server
+    server.close()
+
+
+class TestMetrics:
+    """Test the Prometheus /metrics endpoint"""
+
+    def test_counts_requests_by_path_and_status(self, client, config_file):
+        """Test that handled requests show up in the path and status counters"""
+        client.get("/config")
+        client.get("/config")
+        client.get("/healthz")
+
+        body = client.get("/metrics").text
+
+        assert 'alert_example_requests_total{path="/config"} 2' in body
+        assert 'alert_example_requests_total{path="/healthz"} 1' in body
+        assert 'alert_example_responses_total{code="200"} 3' in body
+
+    def test_path_label_is_bounded(self, client, config_file):
+        """Test that unknown paths share one series and named configs are labelled by their route"""
+        for i in range(5):
+            client.get(f"/scanner-{i}.php")
+            client.get(f"/config/probe-{i}")
+
+        body = client.get("/metrics").text
+
+        assert 'alert_example_requests_total{path="other"} 5' in body
+        assert 'alert_example_requests_total{path="/config/{name:path}"} 5' in body
+        assert "scanner" not in body
+        assert "probe" not in body
+
+    def test_crash_armed_gauge(self, client):
+        """Test that the gauge reports 1 once a crash-armed config has been loaded"""
+        assert "alert_example_crash_armed 0" in client.get("/metrics").text
+
+        alert_app.metrics.set_crash_armed(True)
+
+        assert "alert_example_crash_armed 1" in client.get("/metrics").text
+
+    def test_reset_zeros_counters(self, client, config_file, monkeypatch):
+        """Test that POST /metrics/reset clears the counters when allowed"""
+        monkeypatch.setenv("ALLOW_METRICS_RESET", "true")
+        client.get("/config")
+        client.get("/healthz")
+
+        response = client.post("/metrics/reset")
+
+        assert response.status_code == 204
+        # The reset request itself is counted once it completes
+        body = client.get("/metrics").text
+        assert 'path="/config"' not in body
+        assert 'path="/healthz"' not in body
+        assert 'alert_example_responses_total{code="200"}' not in body
+        assert 'alert_example_requests_total{path="/metrics/reset"} 1' in body
+
+    def test_reset_disabled_by_default(self, client, config_file, monkeypatch):
+        """Test that the reset endpoint is a 404 and leaves counters alone unless enabled"""
+        monkeypatch.delenv("ALLOW_METRICS_RESET", raising=False)
+        client.get("/config")
+
+        assert client.post("/metrics/reset").status_code == 404
+        assert 'alert_example_requests_total{path="/config"} 1' in client.get("/metrics").text
+
+    def test_output_is_prometheus_text_format(self, client):
+        """Test that every family has HELP/TYPE headers and the body ends with a newline"""
+        response = client.get("/metrics")
+
+        assert response.headers["content-type"].startswith("text/plain; version=0.0.4")
+        assert response.text.endswith("\n")
+        for family, kind in [
+            ("alert_example_requests_total", "counter"),
+            ("alert_example_responses_total", "counter"),
+            ("alert_example_crash_armed", "gauge"),
+        ]:
+            assert f"# HELP {family} " in response.text
+            assert f"# TYPE {family} {kind}" in response.text
+
+    def test_label_values_are_escaped(self):
+        """Test that quotes, backslashes and newlines in label values are escaped"""
+        assert alert_app._escape_label_value('a"b\\c\nd') == 'a\\"b\\\\c\\nd'
+
+    def test_runtime_gauges(self, client):
+        """Test that the thread, connection and memory gauges are exposed"""
+        body = client.get("/metrics").text
+
+        threads = int(re.search(r"^alert_example_threads (\d+)$", body, re.M).group(1))
+        assert threads >= 1
+        assert "# TYPE alert_example_active_connections gauge" in body
+        assert int(re.search(r"^alert_example_resident_memory_bytes (\d+)$", body, re.M).group(1)) > 0
+
+    def test_active_connection_gauge_tracks_keep_alive(self):
+        """Test that an open keep-alive connection is counted and uncounted once it closes"""
+        baseline = alert_app.active_connections.value()
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        try:
+            with httpx.Client(base_url=f"http://127.0.0.1:{port}", timeout=5) as http_client:
+                assert http_client.get("/healthz").status_code == 200
+                # The same pooled connection carries the scrape, so it sees itself
+                body = http_client.get("/metrics").text
+                assert f"alert_example_active_connections {baseline + 1}" in body
+                assert alert_app.active_connections.value() == baseline + 1
+
+            assert _wait_for(lambda: alert_app.active_connections.value() == baseline)
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+    def test_config_duration_histogram(self, client, config_file, monkeypatch):
+        """Test that each /config request lands in the histogram with matching _count and _sum"""
+        monkeypatch.setenv("LATENCY_BUCKETS", "0.1,0.05,1")
+        monkeypatch.setattr(alert_app, "metrics", alert_app._Metrics())
+        client.get("/config")
+        client.get("/config")
+        client.get("/healthz")
+
+        body = client.get("/metrics").text
+
+        assert "# TYPE alert_example_request_duration_seconds histogram" in body
+        # Buckets are sorted and cumulative; a local read is well under 1s
+        assert 'alert_example_request_duration_seconds_bucket{le="0.05"}' in body
+        assert 'alert_example_request_duration_seconds_bucket{le="1.0"} 2' in body
+        assert 'alert_example_request_duration_seconds_bucket{le="+Inf"} 2' in body
+        assert "alert_example_request_duration_seconds_count 2" in body
+        total = float(body.split("alert_example_request_duration_seconds_sum ")[1].split("\n")[0])
+        assert 0 < total < 2
+
+    def test_histogram_buckets_follow_observations(self, monkeypatch):
+        """Test that observations are counted in the first bucket that fits and summed"""
+        monkeypatch.setenv("LATENCY_BUCKETS", "0.1,0.5")
+        m = alert_app._Metrics()
+        for seconds in (0.05, 0.2, 0.3, 2.0):
+            m.observe_duration(seconds)
+
+        body = m.render()
+
+        assert 'alert_example_request_duration_seconds_bucket{le="0.1"} 1' in body
+        assert 'alert_example_request_duration_seconds_bucket{le="0.5"} 3' in body
+        assert 'alert_example_request_duration_seconds_bucket{le="+Inf"} 4' in body
+        assert "alert_example_request_duration_seconds_sum 2.55" in body
+        assert "alert_example_request_duration_seconds_count 4" in body
+
+    def test_invalid_latency_buckets_fall_back(self, monkeypatch):
+        """Test that unparseable or non-positive buckets use the default bounds"""
+        for raw in ("fast,slow", "0,1", "-1"):
+            monkeypatch.setenv("LATENCY_BUCKETS", raw)
+            assert alert_app.get_latency_buckets() == alert_app.DEFAULT_LATENCY_BUCKETS
+
+    def test_openmetrics_negotiation(self, client, config_file):
+        """Test that Accept: application/openmetrics-text selects the OpenMetrics format"""
+        client.get("/config")
+
+        response = client.get("/metrics", headers={"Accept": "application/openmetrics-text; version=1.0.0"})
+
+        assert response.headers["content-type"].startswith("application/openmetrics-text; version=1.0.0")
+        assert response.text.endswith("# EOF\n")
+        assert "# TYPE alert_example_requests counter" in response.text
+        assert 'alert_example_requests_total{path="/config"} 1' in response.text
+        assert "# TYPE alert_example_request_duration_seconds histogram" in response.text
+        assert "alert_example_request_duration_seconds_count 1" in response.text
+
+
+
+class TestMetricLabels:
+    """Test the common labels on /metrics series"""
+
+    @staticmethod
+    def _series(body):
+        return [line for line in body.splitlines() if line and not line.startswith("#")]
+
+    def test_every_series_carries_labels(self, client, config_file, monkeypatch):
+        """Test that scenario, instance, pod and METRIC_LABELS appear on every series"""
+        monkeypatch.setenv("SCENARIO", "flaky")
+        monkeypatch.setenv("HOSTNAME", "alert-example-7d9f")
+        monkeypatch.setenv("POD_NAME", "alert-example-7d9f-x2")
+        monkeypatch.setenv("METRIC_LABELS", "team=sre, env=test")
+        client.get("/config")
+
+        series = self._series(client.get("/metrics").text)
+
+        assert series
+        expected = 'scenario="flaky",instance="alert-example-7d9f",pod="alert-example-7d9f-x2",team="sre",env="test"'
+        for line in series:
+            assert expected + "}" in line, line
+        assert f'alert_example_requests_total{{path="/config",{expected}}} 1' in series
+
+    def test_explicit_value_wins(self, client, monkeypatch):
+        """Test that a METRIC_LABELS key duplicating a default replaces its value"""
+        monkeypatch.setenv("HOSTNAME", "from-hostname")
+        monkeypatch.setenv("METRIC_LABELS", "instance=explicit,team=a,team=b")
+
+        series = self._series(client.get("/metrics").text)
+
+        assert 'alert_example_crash_armed{instance="explicit",team="b"} 0' in series
+        assert not any("from-hostname" in line for line in series)
+
+    def test_no_labels_by_default(self, client):
+        """Test that without any source of labels the series are unlabelled"""
+        assert "alert_example_crash_armed 0" in self._series(client.get("/metrics").text)
+
+    def test_invalid_and_reserved_keys_ignored(self, client, monkeypatch):
+        """Test that malformed names and per-series label names are skipped"""
+        monkeypatch.setenv("METRIC_LABELS", "bad-key=x,path=/shadow,novalue,team=sre")
+
+        assert 'alert_example_crash_armed{team="sre"} 0' in self._series(client.get("/metrics").text)
+
+
+class TestMetricsPusher:
+    """Test pushing metrics to a Pushgateway"""
+
+    def test_pushes_at_interval(self, upstream, client):
+        """Test that the current metrics are PUT to the job's group every interval"""
+        client.get("/healthz")
+        pusher = alert_app.MetricsPusher(upstream.url, 0.05)
+        pusher.start()
+        try:
+            assert _wait_for(lambda: len(upstream.requests) >= 3)
+            assert pusher.running()
+        finally:
+            pusher.stop()
+
+        method, path, headers, body = upstream.requests[0]
+        assert method == "PUT"
+        assert path == "/metrics/job/alert-example"
+        assert headers["Content-Type"] == "text/plain; version=0.0.4"
+        assert 'alert_example_requests_total{path="/healthz"} 1' in body.decode()
+
+    def test_final_push_on_stop(self, upstream):
+        """Test that stopping pushes once more, even before the first interval elapses"""
+        pusher = alert_app.MetricsPusher(upstream.url, 60)
+        pusher.start()
+        pusher.stop()
+
+        assert len(upstream.requests) == 1
+        assert not pusher.running()
+
+    def test_labels_are_grouping_key(self, monkeypatch):
+        """Test that the job and common labels form the push path, base64-encoding unsafe values"""
+        monkeypatch.setenv("PUSH_JOB", "fault-injection")
+        monkeypatch.setenv("POD_NAME", "alert-example-x2")
+        monkeypatch.setenv("METRIC_LABELS", "team=sre,route=/api v1")
+
+        url = alert_app.MetricsPusher("http://pushgateway:9091/", 10).push_url()
+
+        assert url == (
+            "http://pushgateway:9091/metrics/job/fault-injection"
+            "/pod/alert-example-x2/team/sre/route@base64/L2FwaSB2MQ=="
+        )
+
+    def test_failed_push_logged(self, upstream, capsys):
+        """Test that a Pushgateway error is reported without raising"""
+        upstream.status = 400
+
+        assert alert_app.MetricsPusher(upstream.url, 10).push() is False
+        assert "pushgateway returned 400" in capsys.readouterr().err
+
+    def test_main_pushes_on_shutdown(self, upstream, config_file, monkeypatch):
+        """Test that main() starts a pusher from PUSHGATEWAY_URL and pushes once after the servers stop"""
+        monkeypatch.setenv("PUSHGATEWAY_URL", upstream.url)
+        monkeypatch.setenv("PUSH_INTERVAL_SECONDS", "60")
+        monkeypatch.setattr(alert_app, "build_servers", lambda *args: [])
+        monkeypatch.setattr(alert_app, "_serve", lambda *servers: asyncio.sleep(0))
+
+        alert_app.main([])
+
+        assert [request[:2] for request in upstream.requests] == [("PUT", "/metrics/job/alert-example")]
+
+
+class TestReadiness:
+    """Test the /readyz readiness probe"""
+
+    def test_not_ready_at_start(self, client, config_file):
+        """Test that /readyz is 503 before the config has ever been read"""
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert "not been loaded" in response.text
+
+    def test_ready_after_successful_read(self, client, config_file):
+        """Test that a successful /config read makes the app ready"""
+        client.get("/config")
+
+        assert client.get("/readyz").status_code == 200
+
+    def test_degraded_after_failed_read(self, client, config_file):
+        """Test that a failed read flips readiness back to 503 and a later success recovers it"""
+        client.get("/config")
+        config_file.unlink()
+        assert client.get("/config").status_code == 500
+
+        response = client.get("/readyz")
+        assert response.status_code == 503
+        assert "most recent config read failed" in response.text
+
+        config_file.write_text('message: "back"\n')
+        client.get("/config")
+        assert client.get("/readyz").status_code == 200
+
+    def test_not_ready_while_ready_file_missing(self, client, config_file, tmp_path, monkeypatch):
+        """Test that READY_FILE keeps /readyz at 503 until the file exists"""
+        monkeypatch.setenv("READY_FILE", str(tmp_path / "ready"))
+        client.get("/config")
+
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert "waiting for ready file" in response.text
+
+    def test_ready_once_ready_file_appears(self, client, config_file, tmp_path, monkeypatch):
+        """Test that the sentinel is re-checked on every probe"""
+        sentinel = tmp_path / "ready"
+        monkeypatch.setenv("READY_FILE", str(sentinel))
+        client.get("/config")
+        assert client.get("/readyz").status_code == 503
+
+        sentinel.write_text("")
+
+        assert client.get("/readyz").status_code == 200
+
+    def test_ready_file_combined_with_config_failure(self, client, config_file, tmp_path, monkeypatch):
+        """Test that an existing ready file does not mask a failed config read"""
+        sentinel = tmp_path / "ready"
+        sentinel.write_text("")
+        monkeypatch.setenv("READY_FILE", str(sentinel))
+        client.get("/config")
+        config_file.unlink()
+        client.get("/config")
+
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert "most recent config read failed" in response.text
+
+    def test_drain_fails_readiness_but_serves_config(self, client, config_file):
+        """Test that drain mode makes /readyz 503 while /config still succeeds"""
+        client.get("/config")
+
+        assert client.post("/admin/drain").json() == {"draining": True}
+
+        response = client.get("/readyz")
+        assert response.status_code == 503
+        assert "draining" in response.text
+        assert client.get("/config").status_code == 200
+
+    def test_undrain_restores_readiness(self, client, config_file):
+        """Test that /admin/undrain clears drain mode"""
+        client.get("/config")
+        client.post("/admin/drain")
+
+        assert client.post("/admin/undrain").json() == {"draining": False}
+        assert client.get("/readyz").status_code == 200
+
+    def test_signal_toggles_drain(self, client, config_file):
+        """Test that each SIGUSR1 flips drain mode"""
+        client.get("/config")
+
+        alert_app.toggle_drain(signal.SIGUSR1)
+        assert client.get("/readyz").status_code == 503
+
+        alert_app.toggle_drain(signal.SIGUSR1)
+        assert client.get("/readyz").status_code == 200
+
+    def test_force_not_ready(self, client, config_file):
+        """Test that state=false fails readiness even with a healthy config"""
+        client.get("/config")
+
+        assert client.post("/admin/ready?state=false").status_code == 200
+
+        response = client.get("/readyz")
+        assert response.status_code == 503
+        assert response.text.splitlines()[-1] == "# readiness forced by /admin/ready?state=false"
+
+    def test_force_ready_despite_failed_read(self, client, config_file):
+        """Test that state=true reports ready although the latest config read failed"""
+        client.get("/config")
+        config_file.unlink()
+        client.get("/config")
+
+        client.post("/admin/ready?state=true")
+
+        response = client.get("/readyz")
+        assert response.status_code == 200
+        assert response.text.splitlines() == ["ok", "# readiness forced by /admin/ready?state=true"]
+
+    def test_auto_clears_override(self, client, config_file):
+        """Test that state=auto hands readiness back to the automatic checks"""
+        client.post("/admin/ready?state=true")
+        assert client.get("/readyz").status_code == 200
+
+        client.post("/admin/ready?state=auto")
+
+        response = client.get("/readyz")
+        assert response.status_code == 503
+        assert "not been loaded" in response.text
+        assert "# readiness forced" not in response.text
+
+    def test_invalid_override_state_rejected(self, client):
+        """Test that unknown states are a 400"""
+        assert client.post("/admin/ready?state=maybe").status_code == 400
+
+    def test_healthz_stays_live_when_not_ready(self, client, config_file):
+        """Test that liveness is independent of readiness"""
+        assert client.get("/readyz").status_code == 503
+        assert client.get("/healthz").status_code == 200
+
+
+class TestDetailedHealth:
+    """Test the DETAILED_HEALTH JSON /healthz payload"""
+
+    def test_plain_by_default(self, client, monkeypatch):
+        """Test that /healthz stays a plain "ok" without the toggle"""
+        monkeypatch.delenv("DETAILED_HEALTH", raising=False)
+
+        response = client.get("/healthz")
+
+        assert response.status_code == 200
+        assert response.text == "ok"
+        assert response.headers["content-type"].startswith("text/plain")
+
+    def test_json_structure(self, client, config_file, monkeypatch):
+        """Test that the detailed payload reports each component and the uptime"""
+        monkeypatch.setenv("DETAILED_HEALTH", "true")
+        monkeypatch.delenv("DEPENDENCY_URL", raising=False)
+        monkeypatch.setattr(alert_app, "process_started", time.monotonic() - 30)
+        monkeypatch.setattr(alert_app, "config_watcher", None)
+        client.get("/config")
+
+        response = client.get("/healthz")
+
+        assert response.status_code == 200
+        body = response.json()
+        assert body["status"] == "ok"
+        assert body["reason"] is None
+        assert body["components"] == {
+            "config": {"loaded": True, "last_read_ok": True},
+            "watcher": {"enabled": False, "running": False},
+            "dependency": {"configured": False, "reachable": None, "reason": None},
+        }
+        assert 30 <= body["uptime_seconds"] < 60
+
+    def test_reports_watcher_and_dependency(self, client, config_file, upstream, monkeypatch):
+        """Test that a running watcher and the last dependency probe are reflected"""
+        monkeypatch.setenv("DETAILED_HEALTH", "true")
+        monkeypatch.setenv("DEPENDENCY_URL", upstream.url)
+        upstream.status = 503
+        watcher = alert_app.ConfigWatcher(lambda: None)
+        watcher.start()
+        monkeypatch.setattr(alert_app, "config_watcher", watcher)
+        try:
+            client.get("/config")
+            client.get("/readyz")
+
+            components = client.get("/healthz").json()["components"]
+        finally:
+            watcher.stop()
+
+        assert components["watcher"] == {"enabled": True, "running": True}
+        assert components["dependency"] == {
+            "configured": True,
+            "reachable": False,
+            "reason": "dependency returned 503",
+        }
+
+    def test_liveness_does_not_probe_dependency(self, client, upstream, monkeypatch):
+        """Test that /healthz only reports the cached probe result"""
+        monkeypatch.setenv("DETAILED_HEALTH", "true")
+        monkeypatch.setenv("DEPENDENCY_URL", upstream.url)
+
+        body = client.get("/healthz").json()
+
+        assert body["components"]["dependency"]["reachable"] is None
+        assert upstream.requests == []
+
+    def test_unhealthy_keeps_503(self, client, config_file, monkeypatch):
+        """Test that a failed liveness is still a 503, now with a JSON body"""
+        monkeypatch.setenv("DETAILED_HEALTH", "true")
+        alert_app.degrade_health("config contained crash keyword")
+
+        response = client.get("/healthz")
+
+        assert response.status_code == 503
+        assert response.json()["status"] == "unhealthy"
+        assert response.json()["reason"] == "config contained crash keyword"
+
+
+class TestResponseDelay:
+    """Test latency injection on /config"""
+
+    def test_env_delay_applies(self, client, config_file, monkeypatch):
+        """Test that RESPONSE_DELAY_MS delays the response"""
+        monkeypatch.setenv("RESPONSE_DELAY_MS", "300")
+
+        started = time.monotonic()
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert time.monotonic() - started >= 0.3
+
+    def test_query_param_overrides_env(self, client, config_file, monkeypatch):
+        """Test that ?delay_ms= takes precedence over the env value"""
+        monkeypatch.setenv("RESPONSE_DELAY_MS", "5000")
+
+        started = time.monotonic()
+        response = client.get("/config?delay_ms=0")
+
+        assert response.status_code == 200
+        assert time.monotonic() - started < 1
+
+    def test_invalid_query_param_rejected(self, client, config_file):
+        """Test that a non-numeric or negative delay_ms is a 400"""
+        assert client.get("/config?delay_ms=soon").status_code == 400
+        assert client.get("/config?delay_ms=-5").status_code == 400
+
+    def test_disconnect_stops_delay_early(self):
+        """Test that the sleep aborts once the client has gone away"""
+
+        class DisconnectingRequest:
+            def __init__(self, after):
+                self._disconnect_at = time.monotonic() + after
+
+            async def is_disconnected(self):
+                return time.monotonic() >= self._disconnect_at
+
+        started = time.monotonic()
+        completed = asyncio.run(alert_app._sleep_unless_disconnected(DisconnectingRequest(0.1), 5.0))
+
+        assert completed is False
+        assert time.monotonic() - started < 1
+
+
+class TestResponsePadding:
+    """Test ?pad_bytes= / RESPONSE_PAD_BYTES filler on /config"""
+
+    BODY = 'message: "all good"\n'
+
+    def test_query_padding(self, client, config_file):
+        """Test that the body is the config followed by exactly pad_bytes of filler"""
+        response = client.get("/config?pad_bytes=5000")
+
+        assert response.status_code == 200
+        assert len(response.content) == len(self.BODY) + 5000
+        assert response.text == self.BODY + "x" * 5000
+
+    def test_env_padding(self, client, config_file, monkeypatch):
+        """Test that RESPONSE_PAD_BYTES pads every response and the query overrides it"""
+        monkeypatch.setenv("RESPONSE_PAD_BYTES", "10")
+
+        assert len(client.get("/config").content) == len(self.BODY) + 10
+        assert client.get("/config?pad_bytes=0").text == self.BODY
+
+    def test_padding_is_deterministic(self, client, config_file):
+        """Test that repeated requests return identical bytes"""
+        first = client.get("/config?pad_bytes=100").content
+        second = client.get("/config?pad_bytes=100").content
+
+        assert hashlib.sha256(first).digest() == hashlib.sha256(second).digest()
+
+    def test_cap_enforced(self, client, config_file, monkeypatch):
+        """Test that padding is clamped to MAX_PAD_BYTES"""
+        monkeypatch.setenv("MAX_PAD_BYTES", "64")
+
+        response = client.get("/config?pad_bytes=100000")
+
+        assert len(response.content) == len(self.BODY) + 64
+
+    def test_invalid_pad_bytes(self, client, config_file):
+        """Test that a negative or non-numeric pad_bytes is a 400"""
+        assert client.get("/config?pad_bytes=-1").status_code == 400
+        assert client.get("/config?pad_bytes=lots").status_code == 400
+
+
+class TestLatencyDistribution:
+    """Test LATENCY_DISTRIBUTION sampling"""
+
+    SAMPLES = 5000
+
+    def _samples(self, monkeypatch, distribution, mean, stddev):
+        monkeypatch.setenv("LATENCY_DISTRIBUTION", distribution)
+        monkeypatch.setenv("LATENCY_MEAN_MS", str(mean))
+        monkeypatch.setenv("LATENCY_STDDEV_MS", str(stddev))
+        monkeypatch.setenv("LATENCY_SEED", "7")
+        monkeypatch.setattr(alert_app, "latency_rng", alert_app._seeded_rng("LATENCY_SEED"))
+        return [alert_app.sample_latency_ms() for _ in range(self.SAMPLES)]
+
+    def test_constant(self, monkeypatch):
+        """Test that constant always yields the mean"""
+        assert set(self._samples(monkeypatch, "constant", 120, 50)) == {120}
+
+    def test_uniform(self, monkeypatch):
+        """Test that uniform samples stay within mean +/- stddev and average to the mean"""
+        samples = self._samples(monkeypatch, "uniform", 200, 50)
+
+        assert min(samples) >= 150 and max(samples) <= 250
+        assert abs(statistics.mean(samples) - 200) < 3
+        # Both halves of the range are used
+        assert min(samples) < 160 and max(samples) > 240
+
+    def test_normal(self, monkeypatch):
+        """Test that normal samples match the configured mean and stddev"""
+        samples = self._samples(monkeypatch, "normal", 300, 40)
+
+        assert abs(statistics.mean(samples) - 300) < 3
+        assert abs(statistics.stdev(samples) - 40) < 3
+        within_two_sigma = sum(220 <= s <= 380 for s in samples) / len(samples)
+        assert 0.93 < within_two_sigma < 0.97
+
+    def test_normal_clamps_negative_samples(self, monkeypatch):
+        """Test that samples below zero are clamped rather than negative"""
+        samples = self._samples(monkeypatch, "normal", 10, 100)
+
+        assert min(samples) == 0
+        assert samples.count(0) > self.SAMPLES / 4
+
+    def test_seed_is_reproducible(self, monkeypatch):
+        """Test that the same LATENCY_SEED yields the same delays"""
+        first = self._samples(monkeypatch, "normal", 100, 30)
+
+        assert self._samples(monkeypatch, "normal", 100, 30) == first
+
+    def test_unset_uses_response_delay(self, monkeypatch):
+        """Test that without a distribution RESPONSE_DELAY_MS still applies"""
+        monkeypatch.delenv("LATENCY_DISTRIBUTION", raising=False)
+        monkeypatch.setenv("RESPONSE_DELAY_MS", "75")
+
+        assert alert_app.sample_latency_ms() == 75
+
+
+class TestLatencyRamp:
+    """Test the LATENCY_RAMP_* linear latency ramp"""
+
+    @pytest.fixture
+    def ramp(self, monkeypatch):
+        monkeypatch.setenv("LATENCY_RAMP_START_MS", "100")
+        monkeypatch.setenv("LATENCY_RAMP_END_MS", "1100")
+        monkeypatch.setenv("LATENCY_RAMP_DURATION_SECONDS", "60")
+
+    def test_interpolates_over_duration(self, ramp):
+        """Test the delay at several points along the ramp and after it"""
+        for elapsed, expected in [(0, 100), (6, 200), (30, 600), (59.4, 1090), (60, 1100), (3600, 1100)]:
+            assert alert_app.latency_ramp_ms(elapsed) == expected
+
+    def test_descending_ramp(self, monkeypatch):
+        """Test that an end below the start ramps latency down"""
+        monkeypatch.setenv("LATENCY_RAMP_START_MS", "500")
+        monkeypatch.setenv("LATENCY_RAMP_END_MS", "0")
+        monkeypatch.setenv("LATENCY_RAMP_DURATION_SECONDS", "10")
+
+        assert [alert_app.latency_ramp_ms(t) for t in (0, 5, 10)] == [500, 250, 0]
+
+    def test_unconfigured(self, monkeypatch):
+        """Test that without start or end there is no ramp"""
+        for name in ("LATENCY_RAMP_START_MS", "LATENCY_RAMP_END_MS"):
+            monkeypatch.delenv(name, raising=False)
+
+        assert alert_app.latency_ramp_ms(10) is None
+
+    def test_sample_uses_uptime(self, ramp, monkeypatch):
+        """Test that /config delays follow the ramp position since process start"""
+        monkeypatch.setenv("RESPONSE_DELAY_MS", "5000")
+        monkeypatch.setattr(alert_app, "process_started", time.monotonic() - 30)
+
+        assert 600 <= alert_app.sample_latency_ms() <= 620
+
+    def test_runtime_delay_replaces_ramp(self, ramp, monkeypatch):
+        """Test that a response_delay_ms set through /admin/config wins over the ramp"""
+        monkeypatch.setattr(alert_app, "process_started", time.monotonic() - 30)
+        alert_app.runtime_settings.apply({"response_delay_ms": 7})
+
+        assert alert_app.sample_latency_ms() == 7
+
+
+class TestErrorInjection:
+    """Test probabilistic error injection on /config"""
+
+    def test_rate_one_fails_every_request(self, client, monkeypatch):
+        """Test that ERROR_RATE=1.0 fails all requests, even without a config file"""
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+        monkeypatch.setenv("ERROR_RATE", "1.0")
+
+        for _ in range(20):
+            response = client.get("/config")
+            assert response.status_code == 500
+            assert response.text == "injected error"
+
+    def test_rate_zero_never_fails(self, client, config_file, monkeypatch):
+        """Test that ERROR_RATE=0.0 never injects an error"""
+        monkeypatch.setenv("ERROR_RATE", "0.0")
+
+        for _ in range(20):
+            assert client.get("/config").status_code == 200
+
+    def test_seed_makes_injection_reproducible(self, monkeypatch):
+        """Test that the same ERROR_SEED yields the same failure pattern"""
+        monkeypatch.setenv("ERROR_RATE", "0.5")
+        monkeypatch.setenv("ERROR_SEED", "42")
+
+        patterns = []
+        for _ in range(2):
+            monkeypatch.setattr(alert_app, "error_rng", alert_app._seeded_rng("ERROR_SEED"))
+            patterns.append([alert_app.should_inject_error() for _ in range(50)])
+
+        assert patterns[0] == patterns[1]
+        assert any(patterns[0]) and not all(patterns[0])
+
+
+def _wait_for(predicate, timeout=3.0):
+    deadline = time.monotonic() + timeout
+    while not predicate():
+        if time.monotonic() > deadline:
+            return False
+        time.sleep(0.02)
+    return True
+
+
+class TestConfigCache:
+    """Test the CONFIG_CACHE_TTL_MS config read cache"""
+
+    @pytest.fixture
+    def clock(self):
+        return _FakeClock()
+
+    @pytest.fixture
+    def reads(self, clock, monkeypatch):
+        reads = []
+
+        def counting_reader(path, limit):
+            reads.append(path)
+            return alert_app._read_config_files(path, limit)
+
+        monkeypatch.setattr(alert_app, "config_cache", alert_app._ConfigCache(reader=counting_reader, clock=clock))
+        monkeypatch.setenv("CONFIG_CACHE_TTL_MS", "1000")
+        return reads
+
+    def test_hit_avoids_reread(self, client, config_file, reads):
+        """Test that requests within the TTL are served from one read"""
+        responses = [client.get("/config") for _ in range(3)]
+
+        assert [r.text for r in responses] == ['message: "all good"\n'] * 3
+        assert len(reads) == 1
+
+    def test_ttl_expiry_rereads(self, client, config_file, reads, clock):
+        """Test that the file is read again once the TTL has passed"""
+        client.get("/config")
+        clock.now += 0.5
+        client.get("/config")
+        assert len(reads) == 1
+
+        clock.now += 0.6
+        client.get("/config")
+        assert len(reads) == 2
+
+    def test_mtime_change_invalidates(self, client, config_file, reads):
+        """Test that a changed file is re-read within the TTL"""
+        client.get("/config")
+        config_file.write_text('message: "updated"\n')
+        st = config_file.stat()
+        os.utime(config_file, ns=(st.st_atime_ns, st.st_mtime_ns + 1_000_000_000))
+
+        response = client.get("/config")
+
+        assert response.text == 'message: "updated"\n'
+        assert len(reads) == 2
+
+    def test_crash_detection_uses_cached_content(self, client, config_file, reads, monkeypatch):
+        """Test that a cached crash-keyword config still triggers the crash path on every hit"""
+        crashes = []
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: crashes.append(True))
+        config_file.write_text('message: "Crash"\n')
+
+        statuses = [client.get("/config").status_code for _ in range(2)]
+
+        assert statuses == [500, 500]
+        assert crashes == [True, True]
+        assert len(reads) == 1
+
+    def test_disabled_by_default(self, client, config_file, reads, monkeypatch):
+        """Test that without CONFIG_CACHE_TTL_MS every request reads the file"""
+        monkeypatch.delenv("CONFIG_CACHE_TTL_MS")
+
+        for _ in range(3):
+            client.get("/config")
+
+        assert len(reads) == 3
+
+
+class TestConfigWatcher:
+    """Test the WATCH_CONFIG file watcher"""
+
+    def test_crash_keyword_write_triggers_crash(self, config_file, monkeypatch):
+        """Test that writing the crash keyword to the watched file invokes the crash path"""
+        crashes = []
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: crashes.append(True))
+        watcher = alert_app.ConfigWatcher(alert_app.handle_config_change)
+        watcher.start()
+        try:
+            time.sleep(0.1)
+            config_file.write_text('message: "Crash now"\n')
+
+            assert _wait_for(lambda: crashes)
+        finally:
+            watcher.stop()
+
+    def test_change_right_after_start_detected(self, config_file):
+        """Test that a write landing before the watcher thread first polls still counts as a change"""
+        changes = []
+        watcher = alert_app.ConfigWatcher(lambda: changes.append(True))
+        watcher.start()
+        try:
+            config_file.write_text('message: "changed straight away"\n')
+
+            assert _wait_for(lambda: changes)
+        finally:
+            watcher.stop()
+
+    def test_rapid_writes_are_debounced(self, config_file):
+        """Test that a burst of writes within the debounce window produces one callback"""
+        changes = []
+        watcher = alert_app.ConfigWatcher(lambda: changes.append(True))
+        watcher.start()
+        try:
+            time.sleep(0.1)
+            for i in range(5):
+                config_file.write_text(f'message: "edit {i}"' + "x" * i)
+                time.sleep(0.03)
+
+            assert _wait_for(lambda: changes)
+            time.sleep(0.4)
+            assert len(changes) == 1
+        finally:
+            watcher.stop()
+
+    def test_healthy_change_does_not_crash(self, config_file, monkeypatch):
+        """Test that a change without the keyword only updates state"""
+        crashes = []
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: crashes.append(True))
+        config_file.write_text('message: "still fine"\n')
+
+        alert_app.handle_config_change()
+
+        assert crashes == []
+        assert alert_app.readiness.check()[0]
+
+
+class TestConfigWatchLongPoll:
+    """Test the /config/watch long-poll endpoint"""
+
+    def test_change_releases_poll_with_new_content(self, client, config_file):
+        """Test that modifying the file answers the held request with the new config"""
+        with concurrent.futures.ThreadPoolExecutor() as pool:
+            poll = pool.submit(client.get, "/config/watch?timeout=5")
+            time.sleep(0.3)
+            config_file.write_text('message: "updated"\n')
+
+            response = poll.result(timeout=5)
+
+        assert response.status_code == 200
+        assert response.text == 'message: "updated"\n'
+
+    def test_timeout_returns_304(self, client, config_file):
+        """Test that an unchanged file ends the poll with 304 after the timeout"""
+        started = time.monotonic()
+        response = client.get("/config/watch?timeout=0.3")
+
+        assert response.status_code == 304
+        assert 0.3 <= time.monotonic() - started < 2.0
+
+    def test_cancellation_ends_poll(self, config_file):
+        """Test that a client disconnect stops waiting"""
+        request = _FakeRequest({"timeout": "10"}, disconnect_after=0.2)
+
+        started = time.monotonic()
+        response = asyncio.run(alert_app.handle_config_watch(request))
+
+        assert response.status_code == 499
+        assert time.monotonic() - started < 2.0
+
+    def test_invalid_timeout_rejected(self, client, config_file):
+        """Test that non-numeric and negative timeouts are a 400"""
+        assert client.get("/config/watch?timeout=soon").status_code == 400
+        assert client.get("/config/watch?timeout=-1").status_code == 400
+
+    def test_requires_credentials(self, client, config_file, monkeypatch):
+        """Test that configured basic auth is checked before the poll starts"""
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+        started = time.monotonic()
+        response = client.get("/config/watch?timeout=5")
+
+        assert response.status_code == 401
+        assert time.monotonic() - started < 2.0
+        assert client.get("/config/watch?timeout=0", auth=("admin", "s3cret")).status_code == 304
+
+
+class TestConfigSizeLimit:
+    """Test MAX_CONFIG_BYTES bounded reads"""
+
+    @pytest.fixture(autouse=True)
+    def limit(self, monkeypatch):
+        monkeypatch.setenv("MAX_CONFIG_BYTES", "32")
+
+    def test_under_limit(self, client, config_file):
+        """Test that a smaller config is served"""
+        config_file.write_text("a" * 31)
+
+        assert client.get("/config").text == "a" * 31
+
+    def test_exactly_at_limit(self, client, config_file):
+        """Test that a config of exactly MAX_CONFIG_BYTES is still served"""
+        config_file.write_text("a" * 32)
+
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == "a" * 32
+
+    def test_over_limit_returns_413(self, client, config_file):
+        """Test that one byte over the limit is a 413 and marks the read failed"""
+        config_file.write_text("a" * 33)
+
+        response = client.get("/config")
+
+        assert response.status_code == 413
+        assert response.text == "config too large"
+        assert not alert_app.readiness.check()[0]
+
+    def test_over_limit_at_startup_exits(self, config_file, monkeypatch, capsys):
+        """Test that the startup read logs and exits on an oversized config"""
+        config_file.write_text("a" * 33)
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+
+        alert_app.startup_check()
+
+        assert exits == [1]
+        assert "exceeds MAX_CONFIG_BYTES=32" in capsys.readouterr().err
+
+
+class TestConfigReadTimeout:
+    """Test CONFIG_READ_TIMEOUT_MS bounding the /config read"""
+
+    @pytest.fixture
+    def slow_read(self, monkeypatch):
+        """Install a read_config that takes the given number of seconds"""
+        def install(seconds):
+            read_config = alert_app.read_config
+
+            def slow_read_config():
+                time.sleep(seconds)
+                return read_config()
+
+            monkeypatch.setattr(alert_app, "read_config", slow_read_config)
+
+        return install
+
+    def test_read_exceeding_timeout_returns_504(self, client, config_file, slow_read, monkeypatch):
+        """Test that a read slower than the timeout is abandoned with a 504"""
+        monkeypatch.setenv("CONFIG_READ_TIMEOUT_MS", "100")
+        slow_read(1.0)
+
+        response = client.get("/config")
+
+        assert response.status_code == 504
+        assert response.text == "config read timed out"
+
+    def test_timeout_does_not_wait_for_read(self, config_file, slow_read, monkeypatch):
+        """Test that the handler is released at the deadline, not when the read finishes"""
+        monkeypatch.setenv("CONFIG_READ_TIMEOUT_MS", "100")
+        slow_read(1.0)
+
+        async def waited():
+            # Timed inside the loop: asyncio.run itself waits for the abandoned read thread
+            started = time.monotonic()
+            with pytest.raises(alert_app.ConfigReadTimeout):
+                await alert_app.read_config_for_request(_FakeRequest())
+            return time.monotonic() - started
+
+        assert asyncio.run(waited()) < 0.5
+
+    def test_read_within_timeout(self, client, config_file, slow_read, monkeypatch):
+        """Test that a read finishing before the deadline is served normally"""
+        monkeypatch.setenv("CONFIG_READ_TIMEOUT_MS", "2000")
+        slow_read(0.1)
+
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == 'message: "all good"\n'
+
+    def test_unbounded_without_timeout(self, client, config_file, slow_read, monkeypatch):
+        """Test that without CONFIG_READ_TIMEOUT_MS a slow read is waited for"""
+        monkeypatch.delenv("CONFIG_READ_TIMEOUT_MS", raising=False)
+        slow_read(0.3)
+
+        assert client.get("/config").status_code == 200
+
+    def test_read_errors_still_reported(self, client, monkeypatch):
+        """Test that a failing read under a timeout is still a 500"""
+        monkeypatch.setenv("CONFIG_READ_TIMEOUT_MS", "1000")
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+
+        assert client.get("/config").status_code == 500
+
+    def test_client_disconnect_abandons_read(self, config_file, slow_read, monkeypatch):
+        """Test that a disconnect ends the wait early without a result"""
+        monkeypatch.setenv("CONFIG_READ_TIMEOUT_MS", "5000")
+        slow_read(1.0)
+        request = _FakeRequest(disconnect_after=0.1)
+
+        async def waited():
+            started = time.monotonic()
+            result = await alert_app.read_config_for_request(request)
+            return result, time.monotonic() - started
+
+        result, elapsed = asyncio.run(waited())
+
+        assert result is None
+        assert elapsed < 0.5
+
+
+class TestYamlValidation:
+    """Test VALIDATE_YAML handling on /config"""
+
+    def test_valid_yaml_is_echoed(self, client, config_file, monkeypatch):
+        """Test that valid YAML is returned unchanged"""
+        monkeypatch.setenv("VALIDATE_YAML", "true")
+
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == 'message: "all good"\n'
+
+    def test_malformed_yaml_returns_422(self, client, config_file, monkeypatch):
+        """Test that a parse failure is surfaced as 422 with the parser's message"""
+        monkeypatch.setenv("VALIDATE_YAML", "true")
+        config_file.write_text("message: [unterminated\n")
+
+        response = client.get("/config")
+
+        assert response.status_code == 422
+        assert response.text.startswith("invalid YAML:")
+        assert "unterminated" in response.text
+
+    def test_validation_disabled_passes_raw_bytes(self, client, config_file, monkeypatch):
+        """Test that without VALIDATE_YAML malformed content is echoed as-is"""
+        monkeypatch.delenv("VALIDATE_YAML", raising=False)
+        config_file.write_text("message: [unterminated\n")
+
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == "message: [unterminated\n"
+
+
+class TestCheck:
+    """Test the --check config validation mode"""
+
+    def test_clean_config(self, config_file, capsys):
+        """Test that a readable, unarmed config passes"""
+        assert alert_app.check_config() == alert_app.CHECK_OK
+        assert "config check passed" in capsys.readouterr().out
+
+    def test_invalid_yaml(self, config_file, monkeypatch, capsys):
+        """Test that malformed YAML fails under VALIDATE_YAML"""
+        monkeypatch.setenv("VALIDATE_YAML", "true")
+        config_file.write_text("message: [unterminated\n")
+
+        assert alert_app.check_config() == alert_app.CHECK_INVALID
+        assert "not valid YAML" in capsys.readouterr().err
+
+    def test_invalid_yaml_ignored_without_validation(self, config_file, monkeypatch):
+        """Test that YAML is only validated when VALIDATE_YAML is set, as when serving"""
+        monkeypatch.delenv("VALIDATE_YAML", raising=False)
+        config_file.write_text("message: [unterminated\n")
+
+        assert alert_app.check_config() == alert_app.CHECK_OK
+
+    def test_unreadable_config(self, monkeypatch):
+        """Test that a missing config fails"""
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+
+        assert alert_app.check_config() == alert_app.CHECK_INVALID
+
+    def test_crash_armed_config(self, config_file, capsys):
+        """Test that a crash keyword fails with its own exit code and names the keyword"""
+        config_file.write_text('message: "Crash"\n')
+
+        assert alert_app.check_config() == alert_app.CHECK_CRASH_ARMED
+        assert "crash-armed (Crash)" in capsys.readouterr().err
+
+    def test_main_check_exits_without_serving(self, config_file, monkeypatch):
+        """Test that --check exits with the verdict and never builds a server"""
+        config_file.write_text('message: "Crash"\n')
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+        monkeypatch.setattr(alert_app, "build_servers", lambda *args: pytest.fail("server built in --check mode"))
+
+        alert_app.main(["--check", "--config", str(config_file)])
+
+        assert exits == [alert_app.CHECK_CRASH_ARMED]
+
+
+class TestVersion:
+    """Test the /version build metadata endpoint"""
+
+    def test_default_shape(self, client):
+        """Test the JSON keys and the defaults used when no build args were set"""
+        response = client.get("/version")
+
+        assert response.status_code == 200
+        assert response.headers["content-type"] == "application/json"
+        assert set(response.json()) == {"version", "commit", "buildDate"}
+
+    def test_defaults_when_unset(self, client, monkeypatch):
+        """Test the placeholder values for an unstamped build"""
+        for name in ("APP_VERSION", "APP_COMMIT", "APP_BUILD_DATE"):
+            monkeypatch.delenv(name, raising=False)
+
+        assert _load_app().handle_version().body == b'{"version":"dev","commit":"none","buildDate":"unknown"}'
+
+    def test_overridden_values(self, client, monkeypatch):
+        """Test that stamped build metadata is reported"""
+        monkeypatch.setattr(alert_app, "version", "1.5.0")
+        monkeypatch.setattr(alert_app, "commit", "abc1234")
+        monkeypatch.setattr(alert_app, "build_date", "2026-01-02T03:04:05Z")
+
+        assert client.get("/version").json() == {
+            "version": "1.5.0",
+            "commit": "abc1234",
+            "buildDate": "2026-01-02T03:04:05Z",
+        }
+
+
+class TestFlags:
+    """Test command-line flag resolution"""
+
+    @pytest.fixture(autouse=True)
+    def clear_env(self, monkeypatch):
+        monkeypatch.delenv("PORT", raising=False)
+        monkeypatch.delenv("CONFIG_PATH", raising=False)
+
+    def test_defaults_without_flags_or_env(self):
+        """Test the built-in defaults"""
+        assert alert_app.resolve_flags([]) == ("0.0.0.0", 8080, "/etc/alert-example/config.yaml")
+
+    def test_env_only(self, monkeypatch):
+        """Test that PORT and CONFIG_PATH still apply when no flags are passed"""
+        monkeypatch.setenv("PORT", "9000")
+        monkeypatch.setenv("CONFIG_PATH", "/env/config.yaml")
+
+        assert alert_app.resolve_flags([]) == ("0.0.0.0", 9000, "/env/config.yaml")
+
+    def test_flags_override_env(self, monkeypatch):
+        """Test that --addr and --config take precedence over the env vars"""
+        monkeypatch.setenv("PORT", "9000")
+        monkeypatch.setenv("CONFIG_PATH", "/env/config.yaml")
+
+        resolved = alert_app.resolve_flags(["--addr", ":9090", "--config", "/flag/config.yaml"])
+
+        assert resolved == ("0.0.0.0", 9090, "/flag/config.yaml")
+
+    def test_addr_with_host(self, monkeypatch):
+        """Test that a host in --addr is kept while CONFIG_PATH still applies"""
+        monkeypatch.setenv("CONFIG_PATH", "/env/config.yaml")
+
+        resolved = alert_app.resolve_flags(["--addr", "127.0.0.1:8081"])
+
+        assert resolved == ("127.0.0.1", 8081, "/env/config.yaml")
+
+    def test_ipv6_addr(self):
+        """Test that a bracketed IPv6 host is unwrapped"""
+        assert alert_app.resolve_listen_address("[::1]:8080") == ("::1", 8080)
+
+    def test_valid_ports(self):
+        """Test that ports in 1-65535 parse"""
+        assert alert_app.parse_port("1") == 1
+        assert alert_app.parse_port("8080") == 8080
+        assert alert_app.parse_port("65535") == 65535
+
+    def test_out_of_range_ports_rejected(self):
+        """Test that 0 and values above 65535 are rejected with a clear message"""
+        for raw in ("0", "65536", "-1"):
+            with pytest.raises(ValueError, match="between 1 and 65535"):
+                alert_app.parse_port(raw)
+
+    def test_non_numeric_port_rejected(self, monkeypatch):
+        """Test that a non-numeric PORT names the variable and value"""
+        monkeypatch.setenv("PORT", "abc")
+
+        with pytest.raises(ValueError, match="invalid PORT 'abc'"):
+            alert_app.get_port()
+
+    def test_empty_port_uses_default(self, monkeypatch):
+        """Test that an empty PORT keeps the 8080 default"""
+        monkeypatch.setenv("PORT", "")
+
+        assert alert_app.get_port() == 8080
+
+    def test_main_exits_on_invalid_port(self, monkeypatch, capsys):
+        """Test that main logs a clear error and exits non-zero for a bad PORT"""
+        monkeypatch.setenv("PORT", "abc")
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+
+        alert_app.main([])
+
+        assert exits == [1]
+        assert "ERROR: invalid PORT 'abc'" in capsys.readouterr().err
+
+
+def _generate_cert(cert_file, key_file):
+    subprocess.run(
+        [
+            "openssl", "req", "-x509", "-newkey", "rsa:2048", "-nodes", "-days", "1",
+            "-subj", "/CN=localhost", "-addext", "subjectAltName=DNS:localhost,IP:127.0.0.1",
+            "-keyout", str(key_file), "-out", str(cert_file),
+        ],
+        check=True,
+        capture_output=True,
+    )
+
+
+@pytest.fixture
+def self_signed_cert(tmp_path):
+    """Generate a self-signed certificate for 127.0.0.1 and return (cert_file, key_file)"""
+    if shutil.which("openssl") is None:
+        pytest.skip("openssl is required to generate a test certificate")
+    cert_file, key_file = tmp_path / "tls.crt", tmp_path / "tls.key"
+    _generate_cert(cert_file, key_file)
+    return str(cert_file), str(key_file)
+
+
+class TestTLS:
+    """Test HTTPS serving"""
+
+    def test_plain_http_by_default(self, monkeypatch):
+        """Test that TLS is off when neither file is configured"""
+        monkeypatch.delenv("TLS_CERT_FILE", raising=False)
+        monkeypatch.delenv("TLS_KEY_FILE", raising=False)
+
+        assert alert_app.get_tls_files() is None
+
+    def test_only_one_file_is_an_error(self, monkeypatch):
+        """Test that a cert without a key (or vice versa) is rejected"""
+        monkeypatch.setenv("TLS_CERT_FILE", "/tls/tls.crt")
+        monkeypatch.delenv("TLS_KEY_FILE", raising=False)
+
+        with pytest.raises(ValueError):
+            alert_app.get_tls_files()
+
+    def test_main_exits_on_partial_tls_config(self, monkeypatch):
+        """Test that main exits non-zero when only one TLS file is set"""
+        monkeypatch.delenv("TLS_CERT_FILE", raising=False)
+        monkeypatch.setenv("TLS_KEY_FILE", "/tls/tls.key")
+
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+
+        alert_app.main([])
+        assert exits == [1]
+
+    def test_https_healthz(self, self_signed_cert, monkeypatch):
+        """Test a successful HTTPS request against a server using the generated cert"""
+        cert_file, key_file = self_signed_cert
+        monkeypatch.setenv("TLS_CERT_FILE", cert_file)
+        monkeypatch.setenv("TLS_KEY_FILE", key_file)
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port, tls_files=alert_app.get_tls_files())
+        thread = _start_server(server)
+        try:
+            context = ssl.create_default_context(cafile=cert_file)
+            response = httpx.get(f"https://127.0.0.1:{port}/healthz", verify=context, timeout=5)
+
+            assert response.status_code == 200
+            assert response.text == "ok"
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+
+class TestCertReload:
+    """Test live TLS certificate rotation"""
+
+    @staticmethod
+    def _presented(port):
+        return ssl.PEM_cert_to_DER_cert(ssl.get_server_certificate(("127.0.0.1", port), timeout=5))
+
+    @staticmethod
+    def _on_disk(cert_file):
+        return ssl.PEM_cert_to_DER_cert(Path(cert_file).read_text())
+
+    def test_rotated_cert_picked_up_and_bad_swap_ignored(self, self_signed_cert, tmp_path, capsys):
+        """Test that new connections get a swapped-in cert, and a broken swap keeps the previous one"""
+        cert_file, key_file = self_signed_cert
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port, tls_files=(cert_file, key_file))
+        thread = _start_server(server)
+        try:
+            original = self._on_disk(cert_file)
+            assert self._presented(port) == original
+
+            new_cert, new_key = tmp_path / "new.crt", tmp_path / "new.key"
+            _generate_cert(new_cert, new_key)
+            rotated = self._on_disk(new_cert)
+            new_key.replace(key_file)
+            new_cert.replace(cert_file)
+
+            assert _wait_for(lambda: self._presented(port) == rotated)
+            assert "TLS certificate reloaded" in capsys.readouterr().out
+
+            Path(cert_file).write_text("not a certificate\n")
+            errors = []
+            assert _wait_for(lambda: errors.append(capsys.readouterr().err) or "failed to reload" in "".join(errors))
+            assert self._presented(port) == rotated
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+    def test_no_reloader_without_tls(self):
+        """Test that plain HTTP servers don't watch any certificate"""
+        assert alert_app.build_server(host="127.0.0.1", port=_free_port()).cert_reloader is None
+
+
+class TestKeepAlive:
+    """Test DISABLE_KEEPALIVE"""
+
+    @pytest.fixture
+    def serve(self):
+        started = []
+
+        def serve():
+            port = _free_port()
+            server = alert_app.build_server(host="127.0.0.1", port=port)
+            started.append((server, _start_server(server)))
+            return port
+
+        yield serve
+        for server, thread in started:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+    def test_disabled_closes_after_each_response(self, serve, monkeypatch):
+        """Test that responses carry Connection: close and the server hangs up after them"""
+        monkeypatch.setenv("DISABLE_KEEPALIVE", "true")
+        port = serve()
+
+        with socket.create_connection(("127.0.0.1", port), timeout=5) as sock:
+            sock.sendall(b"GET /healthz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+            data = b""
+            # Only ends because the server closes the connection; a kept-alive one would time out
+            while chunk := sock.recv(4096):
+                data += chunk
+
+        head, _, body = data.partition(b"\r\n\r\n")
+        assert b"connection: close" in head.lower().split(b"\r\n")
+        assert body == b"ok"
+
+    def test_enabled_by_default(self, serve, monkeypatch):
+        """Test that without the toggle a second request reuses the same connection"""
+        monkeypatch.delenv("DISABLE_KEEPALIVE", raising=False)
+        port = serve()
+        conn = http.client.HTTPConnection("127.0.0.1", port, timeout=5)
+        try:
+            conn.request("GET", "/healthz")
+            first = conn.getresponse()
+            first.read()
+            sock = conn.sock
+
+            conn.request("GET", "/healthz")
+            conn.getresponse().read()
+
+            assert first.getheader("Connection") != "close"
+            assert conn.sock is sock
+        finally:
+            conn.close()
+
+
+class TestMaxHeaderBytes:
+    """Test MAX_HEADER_BYTES"""
+
+    @pytest.fixture
+    def port(self, monkeypatch):
+        monkeypatch.setenv("MAX_HEADER_BYTES", "1024")
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        yield port
+        server.should_exit = True
+        thread.join(timeout=5)
+
+    def test_oversized_headers_rejected(self, port):
+        """Test that headers past the limit are answered with 431"""
+        response = httpx.get(f"http://127.0.0.1:{port}/healthz", headers={"X-Padding": "x" * 2048}, timeout=5)
+
+        assert response.status_code == 431
+        assert response.text == "request header fields too large"
+
+    def test_headers_within_limit_served(self, port):
+        """Test that a request under the limit is served normally"""
+        response = httpx.get(f"http://127.0.0.1:{port}/healthz", headers={"X-Padding": "x" * 512}, timeout=5)
+
+        assert response.status_code == 200
+
+    def test_default_limit(self, monkeypatch):
+        """Test that the limit falls back to Go's 1 MiB default when unset"""
+        monkeypatch.delenv("MAX_HEADER_BYTES", raising=False)
+
+        assert alert_app.get_max_header_bytes() == 1 << 20
+
+
+class TestH2C:
+    """Test ENABLE_H2C cleartext HTTP/2 serving"""
+
+    def test_default_is_http1_server(self, monkeypatch):
+        """Test that without ENABLE_H2C the uvicorn HTTP/1.1 server is used"""
+        monkeypatch.delenv("ENABLE_H2C", raising=False)
+
+        assert isinstance(alert_app.build_server("127.0.0.1", _free_port()), alert_app._Server)
+
+    def test_healthz_over_h2c(self, monkeypatch):
+        """Test a /healthz request negotiated as HTTP/2 over plaintext"""
+        pytest.importorskip("hypercorn")
+        pytest.importorskip("h2")
+        monkeypatch.setenv("ENABLE_H2C", "true")
+        port = _free_port()
+        server = alert_app.build_server("127.0.0.1", port)
+        thread = threading.Thread(target=lambda: asyncio.run(server.serve()), daemon=True)
+        thread.start()
+
+        def accepting():
+            with socket.socket() as sock:
+                return sock.connect_ex(("127.0.0.1", port)) == 0
+
+        try:
+            assert _wait_for(accepting, timeout=5), "h2c server did not start"
+            # Prior-knowledge HTTP/2: no TLS/ALPN and no HTTP/1.1 fallback
+            with httpx.Client(http1=False, http2=True) as h2c_client:
+                response = h2c_client.get(f"http://127.0.0.1:{port}/healthz")
+
+            assert response.status_code == 200
+            assert response.http_version == "HTTP/2"
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+        assert not thread.is_alive()
+
+    def test_keepalive_disabled_caps_requests(self, monkeypatch):
+        """Test that DISABLE_KEEPALIVE limits each hypercorn connection to one request"""
+        pytest.importorskip("hypercorn")
+        monkeypatch.setenv("ENABLE_H2C", "true")
+        monkeypatch.setenv("DISABLE_KEEPALIVE", "true")
+
+        assert alert_app.build_server("127.0.0.1", _free_port()).config.keep_alive_max_requests == 1
+
+    def test_tls_contexts_reload_certs(self, self_signed_cert, monkeypatch):
+        """Test that the TLS context hypercorn creates picks certificates from the reloader, keeping ALPN"""
+        pytest.importorskip("hypercorn")
+        monkeypatch.setenv("ENABLE_H2C", "true")
+
+        server = alert_app.build_server("127.0.0.1", _free_port(), tls_files=self_signed_cert)
+        context = server.config.create_ssl_context()
+
+        assert context.sni_callback == server.cert_reloader.sni_callback
+        assert server.cert_reloader.alpn_protocols == server.config.alpn_protocols
+
+    def test_untracked_connections_warned(self, monkeypatch, capsys):
+        """Test that building the hypercorn server warns that the connections gauge stays at zero"""
+        pytest.importorskip("hypercorn")
+        monkeypatch.setenv("ENABLE_H2C", "true")
+
+        alert_app.build_server("127.0.0.1", _free_port())
+
+        assert "active_connections is not tracked with ENABLE_H2C" in capsys.readouterr().err
+
+
+class TestRequestSpans:
+    """Test the per-request OpenTelemetry span"""
+
+    @pytest.fixture
+    def exporter(self, monkeypatch):
+        from opentelemetry.sdk.trace import TracerProvider
+        from opentelemetry.sdk.trace.export import SimpleSpanProcessor
+        from opentelemetry.sdk.trace.export.in_memory_span_exporter import InMemorySpanExporter
+
+        exporter = InMemorySpanExporter()
+        provider = TracerProvider()
+        provider.add_span_processor(SimpleSpanProcessor(exporter))
+        monkeypatch.setattr(alert_app, "request_tracer", provider.get_tracer("test"))
+        return exporter
+
+    def _request_spans(self, exporter):
+        return [span for span in exporter.get_finished_spans() if span.name == "alert_example.request"]
+
+    def test_one_span_per_request_with_attributes(self, client, config_file, exporter):
+        """Test that /config and /healthz each record a span with path and status"""
+        client.get("/config")
+        client.get("/healthz")
+
+        spans = self._request_spans(exporter)
+        assert [span.attributes["url.path"] for span in spans] == ["/config", "/healthz"]
+        assert all(span.attributes["http.response.status_code"] == 200 for span in spans)
+        assert spans[0].attributes["alert_example.crash_keyword_matched"] is False
+
+    def test_crash_match_is_recorded(self, client, config_file, exporter, monkeypatch):
+        """Test that a crash-armed /config request is flagged on its span"""
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: None)
+        config_file.write_text('message: "Crash now"\n')
+
+        client.get("/config")
+
+        (span,) = self._request_spans(exporter)
+        assert span.attributes["http.response.status_code"] == 500
+        assert span.attributes["alert_example.crash_keyword_matched"] is True
+
+    def test_other_paths_are_not_traced(self, client, exporter):
+        """Test that probe/scrape endpoints outside /config and /healthz add no span"""
+        client.get("/metrics")
+
+        assert self._request_spans(exporter) == []
+
+    def test_no_endpoint_installs_no_exporter(self, monkeypatch):
+        """Test that without OTEL_EXPORTER_OTLP_ENDPOINT tracing setup leaves the no-op provider alone"""
+        monkeypatch.delenv("OTEL_EXPORTER_OTLP_ENDPOINT", raising=False)
+        monkeypatch.setattr(alert_app, "_otel_initialized", False)
+        monkeypatch.setattr(alert_app.trace, "set_tracer_provider", lambda provider: pytest.fail("provider installed"))
+        monkeypatch.setattr(alert_app, "OTLPSpanExporter", lambda **kwargs: pytest.fail("exporter created"))
+
+        alert_app._setup_otel_tracing()
+
+        assert alert_app._otel_initialized
+
+
+class TestPanic:
+    """Test the /panic endpoint and panic recovery"""
+
+    def test_recovered_by_default(self, client, monkeypatch, capsys):
+        """Test that the panic is logged with its stack and answered with a 500"""
+        monkeypatch.delenv("RECOVER_PANICS", raising=False)
+
+        response = client.get("/panic")
+
+        assert response.status_code == 500
+        logged = capsys.readouterr().err
+        assert "recovered panic" in logged
+        assert "Traceback" in logged
+        assert "injected panic via /panic" in logged
+
+    def test_propagated_when_recovery_disabled(self, client, monkeypatch, capsys):
+        """Test that RECOVER_PANICS=false terminates the process"""
+        monkeypatch.setenv("RECOVER_PANICS", "false")
+        exits = []
+
+        class Exited(Exception):
+            pass
+
+        def fake_exit(code):
+            exits.append(code)
+            raise Exited()
+
+        monkeypatch.setattr(alert_app, "exit_func", fake_exit)
+
+        with pytest.raises(Exited):
+            client.get("/panic")
+
+        assert exits == [2]
+        assert "unrecovered panic" in capsys.readouterr().err
+
+
+class TestMemoryLeak:
+    """Test the memory-growth simulator"""
+
+    @pytest.fixture
+    def leak(self, monkeypatch):
+        leak = alert_app._MemoryLeak()
+        monkeypatch.setattr(alert_app, "memory_leak", leak)
+        yield leak
+        leak.stop()
+
+    def test_status_grows_then_stop_halts_growth(self, client, leak):
+        """Test that allocation increases while running and stops after /leak/stop"""
+        assert client.get("/leak/status").json() == {"running": False, "allocated_mb": 0.0}
+
+        leak.start(20, interval=0.05)
+        assert _wait_for(lambda: client.get("/leak/status").json()["allocated_mb"] >= 2)
+
+        stopped = client.post("/leak/stop").json()
+        assert stopped["running"] is False
+        time.sleep(0.2)
+        assert client.get("/leak/status").json()["allocated_mb"] == stopped["allocated_mb"]
+
+    def test_rate_parsing(self, monkeypatch):
+        """Test MEMORY_LEAK_MB_PER_SEC parsing"""
+        monkeypatch.delenv("MEMORY_LEAK_MB_PER_SEC", raising=False)
+        assert alert_app.get_memory_leak_rate() == 0.0
+
+        monkeypatch.setenv("MEMORY_LEAK_MB_PER_SEC", "5")
+        assert alert_app.get_memory_leak_rate() == 5.0
+
+        monkeypatch.setenv("MEMORY_LEAK_MB_PER_SEC", "lots")
+        assert alert_app.get_memory_leak_rate() == 0.0
+
+
+class _FakeRequest:
+    """Minimal stand-in for a Starlette Request whose client disconnects after a delay"""
+
+    def __init__(self, query_params=None, disconnect_after=None, headers=None):
+        self.query_params = query_params or {}
+        self.headers = headers or {}
+        self._disconnect_at = None if disconnect_after is None else time.monotonic() + disconnect_after
+
+    async def is_disconnected(self):
+        return self._disconnect_at is not None and time.monotonic() >= self._disconnect_at
+
+
+class TestBurn:
+    """Test the /burn CPU load endpoint"""
+
+    def test_burns_for_requested_duration(self, client):
+        """Test that the handler runs for roughly the requested time"""
+        started = time.monotonic()
+        response = client.get("/burn?duration_ms=300&workers=2")
+        elapsed = time.monotonic() - started
+
+        assert response.status_code == 200
+        assert response.json()["workers"] == 2
+        assert 0.3 <= elapsed < 2.0
+
+    def test_cancellation_returns_early(self):
+        """Test that a client disconnect stops the workers before the duration elapses"""
+        request = _FakeRequest({"duration_ms": "5000"}, disconnect_after=0.2)
+
+        started = time.monotonic()
+        response = asyncio.run(alert_app.handle_burn(request))
+
+        assert response.status_code == 499
+        assert time.monotonic() - started < 2.0
+
+    def test_invalid_params_rejected(self, client):
+        """Test that non-numeric and out-of-range params are a 400"""
+        assert client.get("/burn?duration_ms=long").status_code == 400
+        assert client.get("/burn?workers=0").status_code == 400
+
+
+class TestSleep:
+    """Test the /sleep endpoint"""
+
+    def test_sleeps_requested_duration(self, client):
+        """Test that the handler holds the request and reports the time slept"""
+        started = time.monotonic()
+        response = client.get("/sleep?ms=200")
+        elapsed = time.monotonic() - started
+
+        assert response.status_code == 200
+        body = response.json()
+        assert body["requested_ms"] == 200
+        assert body["slept_ms"] >= 200
+        assert 0.2 <= elapsed < 2.0
+
+    def test_cap_enforced(self, client, monkeypatch):
+        """Test that MAX_SLEEP_MS clamps longer requests"""
+        monkeypatch.setenv("MAX_SLEEP_MS", "100")
+
+        started = time.monotonic()
+        body = client.get("/sleep?ms=5000").json()
+
+        assert time.monotonic() - started < 1.0
+        assert body["requested_ms"] == 5000
+        assert 100 <= body["slept_ms"] < 1000
+
+    def test_cancellation_returns_early(self):
+        """Test that a client disconnect ends the sleep"""
+        request = _FakeRequest({"ms": "5000"}, disconnect_after=0.2)
+
+        started = time.monotonic()
+        response = asyncio.run(alert_app.handle_sleep(request))
+
+        assert response.status_code == 499
+        assert time.monotonic() - started < 2.0
+
+    def test_invalid_values_rejected(self, client):
+        """Test that negative and non-numeric durations are a 400"""
+        assert client.get("/sleep?ms=-1").status_code == 400
+        assert client.get("/sleep?ms=forever").status_code == 400
+
+
+class TestSlow:
+    """Test the /slow trickling endpoint"""
+
+    def test_duration_scales_with_byte_delay(self, client, config_file, monkeypatch):
+        """Test that the total write time grows with byte_delay_ms"""
+        monkeypatch.setenv("MAX_SLOW_BYTES", "6")
+
+        def timed_get(byte_delay_ms):
+            started = time.monotonic()
+            response = client.get(f"/slow?byte_delay_ms={byte_delay_ms}")
+            return response, time.monotonic() - started
+
+        fast, fast_elapsed = timed_get(20)
+        slow, slow_elapsed = timed_get(100)
+
+        assert fast.status_code == slow.status_code == 200
+        assert fast.text == slow.text == "messag"
+        # Five gaps between six bytes
+        assert fast_elapsed >= 0.1
+        assert slow_elapsed >= 0.5
+        assert slow_elapsed > fast_elapsed * 2
+
+    def test_body_capped(self, client, config_file, monkeypatch):
+        """Test that MAX_SLOW_BYTES truncates the body"""
+        monkeypatch.setenv("MAX_SLOW_BYTES", "3")
+
+        assert client.get("/slow?byte_delay_ms=0").text == "mes"
+
+    def test_cancellation_stops_stream(self, config_file):
+        """Test that a client disconnect stops the trickle mid-stream"""
+        request = _FakeRequest({"byte_delay_ms": "100"}, disconnect_after=0.25)
+
+        async def consume():
+            response = await alert_app.handle_slow(request)
+            return [chunk async for chunk in response.body_iterator]
+
+        chunks = asyncio.run(consume())
+
+        assert 1 <= len(chunks) < len('message: "all good"\n')
+
+    def test_invalid_param_rejected(self, client, config_file):
+        """Test that non-numeric and negative delays are a 400"""
+        assert client.get("/slow?byte_delay_ms=slow").status_code == 400
+        assert client.get("/slow?byte_delay_ms=-1").status_code == 400
+
+    def test_requires_credentials(self, client, config_file, monkeypatch):
+        """Test that configured basic auth protects the trickled config"""
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+        monkeypatch.setenv("MAX_SLOW_BYTES", "3")
+
+        response = client.get("/slow?byte_delay_ms=0")
+
+        assert response.status_code == 401
+        assert client.get("/slow?byte_delay_ms=0", auth=("admin", "s3cret")).text == "mes"
+
+
+class TestStatusOverride:
+    """Test the ?status= and DEFAULT_STATUS overrides on /config"""
+
+    def test_query_param_overrides_status_and_keeps_body(self, client, config_file):
+        """Test that ?status=503 changes the code but still returns the config"""
+        response = client.get("/config?status=503")
+
+        assert response.status_code == 503
+        assert response.text == 'message: "all good"\n'
+
+    def test_invalid_status_rejected(self, client, config_file):
+        """Test that out-of-range or non-numeric codes are a 400"""
+        for raw in ("600", "99", "teapot"):
+            assert client.get(f"/config?status={raw}").status_code == 400
+
+    def test_informational_status_rejected(self, client, config_file, monkeypatch):
+        """Test that 1xx codes are a 400 per request and ignored in DEFAULT_STATUS"""
+        for raw in ("100", "101", "199"):
+            assert client.get(f"/config?status={raw}").status_code == 400
+
+        monkeypatch.setenv("DEFAULT_STATUS", "103")
+        assert client.get("/config").status_code == 200
+
+    @pytest.mark.parametrize("code", [204, 304])
+    def test_bodyless_status_sent_empty(self, client, config_file, code):
+        """Test that 204 and 304 go out with no body and no Content-Length"""
+        response = client.get(f"/config?status={code}")
+
+        assert response.status_code == code
+        assert response.content == b""
+        assert "content-length" not in response.headers
+
+    def test_default_passthrough(self, client, config_file, monkeypatch):
+        """Test that without overrides the status is 200"""
+        monkeypatch.delenv("DEFAULT_STATUS", raising=False)
+
+        assert client.get("/config").status_code == 200
+
+    def test_env_default_and_query_precedence(self, client, config_file, monkeypatch):
+        """Test that DEFAULT_STATUS applies globally and ?status= still wins"""
+        monkeypatch.setenv("DEFAULT_STATUS", "429")
+
+        assert client.get("/config").status_code == 429
+        assert client.get("/config?status=202").status_code == 202
+
+
+class TestStatusSequence:
+    """Test STATUS_SEQUENCE on /config"""
+
+    def test_cycles_through_sequence(self, client, config_file, monkeypatch):
+        """Test that successive requests follow the pattern exactly, wrapping at the end"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "200,200,500,503")
+
+        statuses = [client.get("/config").status_code for _ in range(8)]
+
+        assert statuses == [200, 200, 500, 503, 200, 200, 500, 503]
+
+    def test_body_served_with_sequenced_status(self, client, config_file, monkeypatch):
+        """Test that the config is still returned under a sequenced error status"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "503")
+
+        response = client.get("/config")
+
+        assert response.status_code == 503
+        assert response.text == 'message: "all good"\n'
+
+    def test_empty_disables(self, client, config_file, monkeypatch):
+        """Test that an empty STATUS_SEQUENCE falls back to DEFAULT_STATUS"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "")
+        monkeypatch.setenv("DEFAULT_STATUS", "202")
+
+        assert client.get("/config").status_code == 202
+
+    def test_invalid_sequence_ignored(self, client, config_file, monkeypatch):
+        """Test that a sequence with an invalid code is ignored"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "200,teapot")
+
+        assert client.get("/config").status_code == 200
+
+        monkeypatch.setenv("STATUS_SEQUENCE", "503,100")
+        assert client.get("/config").status_code == 200
+
+    def test_bodyless_status_in_sequence_over_http(self, config_file, monkeypatch):
+        """Test that a sequenced 204 goes out empty and leaves the connection usable for the next response"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "204,200")
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        try:
+            with httpx.Client(base_url=f"http://127.0.0.1:{port}", timeout=5) as http:
+                empty = http.get("/config")
+                full = http.get("/config")
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+        assert (empty.status_code, empty.content) == (204, b"")
+        assert (full.status_code, full.text) == (200, 'message: "all good"\n')
+
+    def test_query_param_wins(self, client, config_file, monkeypatch):
+        """Test that ?status= overrides the sequence without advancing it"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "500,503")
+
+        assert client.get("/config?status=202").status_code == 202
+        assert client.get("/config").status_code == 500
+
+    def test_concurrent_requests_share_one_index(self, monkeypatch):
+        """Test that concurrent callers each consume exactly one position"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "200,500")
+        sequence = alert_app._StatusSequence()
+
+        with concurrent.futures.ThreadPoolExecutor(max_workers=8) as pool:
+            statuses = list(pool.map(lambda _: sequence.next_status(), range(200)))
+
+        assert statuses.count(200) == statuses.count(500) == 100
+
+
+class TestRequestID:
+    """Test X-Request-ID propagation"""
+
+    def test_supplied_header_is_echoed(self, client):
+        """Test that an incoming X-Request-ID is returned unchanged"""
+        response = client.get("/healthz", headers={"X-Request-ID": "req-123"})
+
+        assert response.headers["X-Request-ID"] == "req-123"
+
+    def test_missing_header_generates_uuid(self, client):
+        """Test that a UUID is generated when the client sends no ID"""
+        response = client.get("/healthz")
+
+        assert uuid.UUID(response.headers["X-Request-ID"])
+
+    def test_request_id_is_included_in_logs(self, client, monkeypatch, capsys):
+        """Test that log lines emitted while handling a request carry its ID"""
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+
+        client.get("/config", headers={"X-Request-ID": "req-456"})
+
+        assert "request_id=req-456" in capsys.readouterr().err
+
+    def test_no_request_id_outside_requests(self):
+        """Test the helper outside of request handling"""
+        assert alert_app.request_id_from_context() is None
+
+
+class TestCrashLogCorrelation:
+    """Test that the request-triggered crash log identifies the request"""
+
+    @pytest.fixture(autouse=True)
+    def crash_config(self, config_file, monkeypatch):
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: None)
+        monkeypatch.setenv("CRASH_KEYWORD", "Crash,Boom")
+        config_file.write_text('message: "Boom"\n')
+
+    def test_text_format(self, client, monkeypatch, capsys):
+        """Test that the text crash line carries the request ID, remote address and keyword"""
+        monkeypatch.delenv("LOG_FORMAT", raising=False)
+
+        response = client.get("/config", headers={"X-Request-ID": "req-crash-1", "X-Forwarded-For": "10.1.2.3"})
+
+        assert response.status_code == 500
+        [line] = [line for line in capsys.readouterr().err.splitlines() if "crash keyword, terminating" in line]
+        assert "request_id=req-crash-1" in line
+        assert "remote_addr=testclient" in line
+        assert "forwarded_for=10.1.2.3" in line
+        assert "keywords_matched=Boom" in line
+
+    def test_json_format(self, client, monkeypatch, capsys):
+        """Test that the JSON crash event carries the same fields"""
+        monkeypatch.setenv("LOG_FORMAT", "json")
+
+        client.get("/config", headers={"X-Request-ID": "req-crash-2"})
+
+        events = [json.loads(line) for line in capsys.readouterr().err.splitlines() if line.startswith("{")]
+        [event] = [event for event in events if event["msg"] == "config contained crash keyword, terminating"]
+        assert event["request_id"] == "req-crash-2"
+        assert event["remote_addr"] == "testclient"
+        assert "forwarded_for" not in event
+        assert event["keywords_matched"] == "Boom"
+        assert event["path"] == "/config"
+
+
+class TestReload:
+    """Test POST /config/reload"""
+
+    def test_reload_reflects_swapped_file(self, client, config_file, monkeypatch):
+        """Test that swapping the file and reloading reports the new crash state"""
+        crashes = []
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: crashes.append(True))
+
+        assert client.post("/config/reload").json() == {"success": True, "crash_armed": False}
+        assert crashes == []
+
+        config_file.write_text('message: "Crash after swap"\n')
+
+        assert client.post("/config/reload").json() == {"success": True, "crash_armed": True}
+        assert crashes == [True]
+
+    def test_reload_updates_readiness(self, client, config_file):
+        """Test that a reload counts as a config read for readiness"""
+        assert client.get("/readyz").status_code == 503
+
+        client.post("/config/reload")
+
+        assert client.get("/readyz").status_code == 200
+
+    def test_reload_failure(self, client, monkeypatch):
+        """Test that an unreadable config is reported as a failed reload"""
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+
+        response = client.post("/config/reload", headers={"Accept": "application/json"})
+
+        assert response.status_code == 500
+        assert response.json()["error"]["code"] == "config_reload_failed"
+        assert client.get("/readyz").status_code == 503
+
+
+class TestConfigHistory:
+    """Test the /config/history record of observed config versions"""
+
+    def test_reloads_recorded_in_order_and_deduplicated(self, client, config_file):
+        """Test that each distinct version appears once, oldest first, and repeats are dropped"""
+        client.post("/config/reload")
+        client.post("/config/reload")
+        config_file.write_text("version: 2\n")
+        client.post("/config/reload")
+        config_file.write_text('message: "all good"\n')
+        client.post("/config/reload")
+
+        history = client.get("/config/history").json()
+
+        assert [entry["content"] for entry in history] == [
+            'message: "all good"\n',
+            "version: 2\n",
+            'message: "all good"\n',
+        ]
+        assert all(entry["reason"] == "reload requested" for entry in history)
+        assert history[1]["sha256"] == hashlib.sha256(b"version: 2\n").hexdigest()
+        assert history[0]["observed_at"] <= history[1]["observed_at"] <= history[2]["observed_at"]
+
+    def test_bounded_by_history_size(self, client, config_file, monkeypatch):
+        """Test that only the newest CONFIG_HISTORY_SIZE versions are kept"""
+        monkeypatch.setenv("CONFIG_HISTORY_SIZE", "2")
+        for version in range(4):
+            config_file.write_text(f"version: {version}\n")
+            client.post("/config/reload")
+
+        history = client.get("/config/history").json()
+
+        assert [entry["content"] for entry in history] == ["version: 2\n", "version: 3\n"]
+
+    def test_watcher_changes_recorded(self, config_file):
+        """Test that changes picked up by the file watcher are recorded"""
+        watcher = alert_app.ConfigWatcher(alert_app.handle_config_change)
+        watcher.start()
+        try:
+            time.sleep(0.1)
+            config_file.write_text("version: 2\n")
+
+            assert _wait_for(lambda: alert_app.config_history.entries())
+        finally:
+            watcher.stop()
+
+        assert alert_app.config_history.entries()[-1]["content"] == "version: 2\n"
+        assert alert_app.config_history.entries()[-1]["reason"] == "file changed"
+
+    def test_failed_reload_not_recorded(self, client, monkeypatch):
+        """Test that unreadable configs leave the history empty"""
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+        client.post("/config/reload")
+
+        assert client.get("/config/history").json() == []
+
+    def test_requires_credentials(self, client, config_file, monkeypatch):
+        """Test that configured basic auth protects the recorded contents"""
+        client.post("/config/reload")
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+        response = client.get("/config/history")
+
+        assert response.status_code == 401
+        assert "all good" not in response.text
+        assert len(client.get("/config/history", auth=("admin", "s3cret")).json()) == 1
+
+
+class TestConfigDirectory:
+    """Test CONFIG_PATH pointing at a directory of fragments"""
+
+    @pytest.fixture
+    def config_dir(self, tmp_path, monkeypatch):
+        directory = tmp_path / "conf.d"
+        directory.mkdir()
+        monkeypatch.setenv("CONFIG_PATH", str(directory))
+        return directory
+
+    def test_fragments_concatenated_in_sorted_order(self, client, config_dir):
+        """Test that *.yaml files are joined by name order and other files ignored"""
+        (config_dir / "20-b.yaml").write_text("b: 2\n")
+        (config_dir / "10-a.yaml").write_text("a: 1")
+        (config_dir / "notes.txt").write_text("ignored\n")
+
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == "a: 1\nb: 2\n"
+
+    def test_crash_keyword_in_any_fragment(self, client, config_dir, monkeypatch):
+        """Test that one crash-armed fragment arms the whole config"""
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: None)
+        (config_dir / "a.yaml").write_text('message: "fine"\n')
+        (config_dir / "b.yaml").write_text('message: "Crash"\n')
+
+        assert client.get("/config").text == "config triggered crash"
+
+    def test_empty_directory(self, client, config_dir):
+        """Test that a directory without fragments yields an empty config"""
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == ""
+
+    def test_single_file_unchanged(self, client, config_file):
+        """Test that a file path still reads just that file"""
+        assert client.get("/config").text == 'message: "all good"\n'
+
+
+class TestConfigURL:
+    """Test CONFIG_PATH pointing at an http(s) URL"""
+
+    @pytest.fixture
+    def config_url(self, upstream, monkeypatch):
+        upstream.body = b'message: "from upstream"\n'
+        monkeypatch.setenv("CONFIG_PATH", upstream.url + "/config.yaml")
+        return upstream
+
+    def test_serves_fetched_config(self, client, config_url):
+        """Test that the body fetched from the URL is served and marks the app ready"""
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert response.text == 'message: "from upstream"\n'
+        assert config_url.requests[0][:2] == ("GET", "/config.yaml")
+        assert alert_app.readiness.check()[0]
+
+    def test_crash_keyword_in_fetched_config(self, client, config_url, monkeypatch):
+        """Test that the crash keyword is matched in the fetched body"""
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: None)
+        config_url.body = b'message: "Crash"\n'
+
+        assert client.get("/config").text == "config triggered crash"
+
+    def test_fetch_failure_returns_500(self, client, config_url):
+        """Test that an upstream error status is a failed read"""
+        config_url.status = 503
+
+        response = client.get("/config")
+
+        assert response.status_code == 500
+        assert response.text == "failed to read config"
+        assert not alert_app.readiness.check()[0]
+
+    def test_unreachable_url_returns_500(self, client, monkeypatch):
+        """Test that a connection failure is a failed read"""
+        monkeypatch.setenv("CONFIG_PATH", f"http://127.0.0.1:{_free_port()}/config.yaml")
+        monkeypatch.setenv("CONFIG_FETCH_TIMEOUT_SECONDS", "1")
+
+        assert client.get("/config").status_code == 500
+
+    def test_fetched_config_over_limit(self, client, config_url, monkeypatch):
+        """Test that MAX_CONFIG_BYTES also bounds the fetched body"""
+        monkeypatch.setenv("MAX_CONFIG_BYTES", "8")
+
+        assert client.get("/config").status_code == 413
+
+    def test_startup_check_fetches_url(self, config_url, monkeypatch):
+        """Test that the startup read fetches the URL and exits on a crash-armed body"""
+        config_url.body = b'message: "Crash"\n'
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+
+        alert_app.startup_check()
+
+        assert exits == [1]
+        assert len(config_url.requests) == 1
+
+
+class TestConfigStream:
+    """Test /config/stream"""
+
+    @pytest.fixture
+    def port(self):
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        yield port
+        server.should_exit = True
+        thread.join(timeout=5)
+
+    @pytest.fixture
+    def exits(self, monkeypatch):
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+        monkeypatch.setenv("CRASH_DELAY_MS", "0")
+        return exits
+
+    def test_streams_file_with_content_length(self, client, config_file):
+        """Test that a multi-chunk file arrives intact with Content-Length from the file size"""
+        data = b"".join(b"line %06d: all good\n" % i for i in range(20000))
+        config_file.write_bytes(data)
+
+        response = client.get("/config/stream")
+
+        assert response.status_code == 200
+        assert response.headers["content-length"] == str(len(data))
+        assert response.content == data
+        assert alert_app.readiness.check()[0]
+
+    def test_keyword_split_across_chunks(self):
+        """Test that the scanner finds a keyword straddling a chunk boundary"""
+        scanner = alert_app.CrashScanner(["Crash", "Boom"])
+
+        assert [scanner.feed(chunk) for chunk in (b"all good, Cr", b"a", b"sh here")] == [False, False, True]
+        assert scanner.matched == ["Crash"]
+
+    def test_crash_keyword_aborts_mid_stream(self, capfd, port, config_file, exits):
+        """Test that the stream stops before the chunk holding the keyword and the crash exit runs"""
+        baseline = alert_app.active_connections.value()
+        offset = 3 * alert_app.STREAM_CHUNK_BYTES + 100
+        config_file.write_bytes(b"x" * offset + b'message: "Crash"\n' + b"y" * alert_app.STREAM_CHUNK_BYTES)
+        received = bytearray()
+
+        with pytest.raises(httpx.HTTPError):
+            with httpx.stream("GET", f"http://127.0.0.1:{port}/config/stream", timeout=5) as response:
+                assert response.status_code == 200
+                for chunk in response.iter_bytes():
+                    received += chunk
+
+        assert len(received) == 3 * alert_app.STREAM_CHUNK_BYTES
+        assert b"Crash" not in received
+        assert exits == [1]
+        assert _wait_for(lambda: alert_app.active_connections.value() == baseline)
+        assert "Exception in ASGI application" not in capfd.readouterr().err
+
+    def test_disconnect_stops_reading(self, port, config_file, capsys):
+        """Test that closing the connection mid-stream stops the server reading the file"""
+        config_file.write_bytes(b"x" * (32 * 1024 * 1024))
+
+        with httpx.stream("GET", f"http://127.0.0.1:{port}/config/stream", timeout=5) as response:
+            next(response.iter_raw())
+
+        output = []
+        assert _wait_for(lambda: output.append(capsys.readouterr().out) or "stopping config stream" in "".join(output))
+
+    def test_growing_file_stops_at_content_length(self, config_file):
+        """Test that bytes appended mid-stream are not sent past the Content-Length taken at open"""
+        data = b"x" * (2 * alert_app.STREAM_CHUNK_BYTES + 10)
+        config_file.write_bytes(data)
+
+        async def consume():
+            response = await alert_app.handle_config_stream(_FakeRequest())
+            received = bytearray()
+            async for chunk in response.body_iterator:
+                if not received:
+                    with open(config_file, "ab") as f:
+                        f.write(b"y" * alert_app.STREAM_CHUNK_BYTES)
+                received += chunk
+            return response, bytes(received)
+
+        response, received = asyncio.run(consume())
+
+        assert response.headers["content-length"] == str(len(data))
+        assert received == data
+
+    def test_directory_not_streamable(self, client, tmp_path, monkeypatch):
+        """Test that a config directory is a 400"""
+        monkeypatch.setenv("CONFIG_PATH", str(tmp_path))
+
+        assert client.get("/config/stream").status_code == 400
+
+    def test_missing_file(self, client, config_file):
+        """Test that an unreadable config is a 500 and fails readiness"""
+        config_file.unlink()
+
+        assert client.get("/config/stream").status_code == 500
+        assert not alert_app.readiness.check()[0]
+
+
+class TestConfigTruncated:
+    """Test /config/truncated"""
+
+    BODY = b'message: "all good"\n'
+
+    @pytest.fixture
+    def port(self):
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        yield port
+        server.should_exit = True
+        thread.join(timeout=5)
+
+    @staticmethod
+    def _get(port, target):
+        with socket.create_connection(("127.0.0.1", port), timeout=5) as sock:
+            sock.sendall(b"GET " + target.encode() + b" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+            data = b""
+            # HTTP/1.1 keeps the connection open, so this only ends because the server drops it
+            while chunk := sock.recv(4096):
+                data += chunk
+        head, _, body = data.partition(b"\r\n\r\n")
+        return head.lower().split(b"\r\n"), body
+
+    def test_closes_after_query_bytes(self, port, config_file):
+        """Test that only ?bytes= of the body arrive before the connection closes short of Content-Length"""
+        head, body = self._get(port, "/config/truncated?bytes=10")
+
+        assert head[0] == b"http/1.1 200 ok"
+        assert b"content-length: %d" % len(self.BODY) in head
+        assert body == self.BODY[:10]
+
+    def test_env_default_and_clamp(self, port, config_file, monkeypatch):
+        """Test that TRUNCATE_BYTES applies without a query and the cut always leaves the body incomplete"""
+        monkeypatch.setenv("TRUNCATE_BYTES", "4")
+        assert self._get(port, "/config/truncated")[1] == self.BODY[:4]
+
+        assert self._get(port, "/config/truncated?bytes=1000")[1] == self.BODY[:-1]
+
+    def test_client_sees_incomplete_read(self, port, config_file):
+        """Test that an HTTP client reports the short body as an error"""
+        conn = http.client.HTTPConnection("127.0.0.1", port, timeout=5)
+        try:
+            conn.request("GET", "/config/truncated?bytes=5")
+            response = conn.getresponse()
+
+            with pytest.raises(http.client.IncompleteRead) as excinfo:
+                response.read()
+        finally:
+            conn.close()
+
+        assert excinfo.value.partial == self.BODY[:5]
+
+    def test_cut_not_logged_as_error(self, capfd, port, config_file):
+        """Test that the deliberate cut leaves neither a traceback nor an unfinished-response error in the log"""
+        baseline = alert_app.active_connections.value()
+
+        assert self._get(port, "/config/truncated?bytes=5")[1] == self.BODY[:5]
+
+        assert _wait_for(lambda: alert_app.active_connections.value() == baseline)
+        err = capfd.readouterr().err
+        assert "Exception in ASGI application" not in err
+        assert "without completing response" not in err
+
+    def test_invalid_bytes(self, client, config_file):
+        """Test that a non-numeric or negative byte count is a 400"""
+        assert client.get("/config/truncated?bytes=lots").status_code == 400
+        assert client.get("/config/truncated?bytes=-1").status_code == 400
+
+
+class TestNamedConfig:
+    """Test /config/<name> serving CONFIG_PATH/<name>.yaml"""
+
+    @pytest.fixture
+    def config_dir(self, tmp_path, monkeypatch):
+        directory = tmp_path / "configs"
+        directory.mkdir()
+        (tmp_path / "secret.yaml").write_text("password: hunter2\n")
+        monkeypatch.setenv("CONFIG_PATH", str(directory))
+        return directory
+
+    def test_serves_named_file(self, client, config_dir):
+        """Test that a valid name serves the matching file"""
+        (config_dir / "checkout.yaml").write_text("service: checkout\n")
+
+        response = client.get("/config/checkout")
+
+        assert response.status_code == 200
+        assert response.text == "service: checkout\n"
+
+    def test_base_is_directory_of_config_file(self, client, config_file):
+        """Test that a file CONFIG_PATH resolves names next to it"""
+        (config_file.parent / "other.yaml").write_text("other: true\n")
+
+        assert client.get("/config/other").text == "other: true\n"
+
+    def test_traversal_rejected(self, client, config_dir):
+        """Test that names escaping the directory are a 400"""
+        for path in ("/config/..%2Fsecret", "/config/%2e%2e", "/config/sub%2F..%2F..%2Fsecret"):
+            response = client.get(path)
+            assert response.status_code == 400, path
+            assert "hunter2" not in response.text
+
+    def test_symlink_outside_rejected(self, client, config_dir):
+        """Test that a symlink pointing out of the directory is refused"""
+        (config_dir / "link.yaml").symlink_to(config_dir.parent / "secret.yaml")
+
+        assert client.get("/config/link").status_code == 400
+
+    def test_missing_name_returns_404(self, client, config_dir):
+        """Test that an unknown name is a 404"""
+        response = client.get("/config/nope")
+
+        assert response.status_code == 404
+        assert response.text == "no config named nope"
+
+    def test_crash_keyword_per_file(self, client, config_dir, monkeypatch):
+        """Test that only the crash-armed named file triggers the crash path"""
+        crashes = []
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: crashes.append(True))
+        (config_dir / "good.yaml").write_text('message: "fine"\n')
+        (config_dir / "bad.yaml").write_text('message: "Crash"\n')
+
+        assert client.get("/config/good").status_code == 200
+        assert crashes == []
+
+        response = client.get("/config/bad")
+
+        assert response.status_code == 500
+        assert response.text == "config triggered crash"
+        assert crashes == [True]
+
+    def test_url_config_rejected(self, client, monkeypatch):
+        """Test that an http(s) CONFIG_PATH has no directory to serve names from"""
+        monkeypatch.setenv("CONFIG_PATH", "http://config.example/app.yaml")
+
+        response = client.get("/config/other")
+
+        assert response.status_code == 400
+        assert response.text == "named configs need a CONFIG_PATH file or directory"
+
+    def test_requires_credentials(self, client, config_file, monkeypatch):
+        """Test that configured basic auth protects named configs, including CONFIG_PATH's own file"""
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+        response = client.get("/config/config")
+
+        assert response.status_code == 401
+        assert "all good" not in response.text
+        assert client.get("/config/config", auth=("admin", "s3cret")).text == 'message: "all good"\n'
+
+    def test_fixed_routes_still_matched(self, client, config_dir):
+        """Test that /config/history is not treated as a named config"""
+        assert client.get("/config/history").json() == []
+
+
+class TestStartupFailure:
+    """Test STARTUP_FAILURE_RATE / STARTUP_SEED flaky startup"""
+
+    def test_rate_one_always_fails(self, monkeypatch):
+        """Test that a rate of 1.0 fails every start"""
+        monkeypatch.setenv("STARTUP_FAILURE_RATE", "1.0")
+
+        assert all(alert_app.should_fail_startup() for _ in range(50))
+
+    def test_rate_zero_never_fails(self, monkeypatch):
+        """Test that a rate of 0 (or unset) preserves normal startup"""
+        monkeypatch.setenv("STARTUP_FAILURE_RATE", "0")
+        assert not any(alert_app.should_fail_startup() for _ in range(50))
+
+        monkeypatch.delenv("STARTUP_FAILURE_RATE")
+        assert not alert_app.should_fail_startup()
+
+    def test_seed_is_reproducible(self, monkeypatch):
+        """Test that the same STARTUP_SEED gives the same decision and different seeds vary"""
+        monkeypatch.setenv("STARTUP_FAILURE_RATE", "0.5")
+        decisions = {}
+        for seed in range(20):
+            monkeypatch.setenv("STARTUP_SEED", str(seed))
+            decisions[seed] = alert_app.should_fail_startup()
+            assert all(alert_app.should_fail_startup() == decisions[seed] for _ in range(5))
+
+        assert set(decisions.values()) == {True, False}
+
+    def test_main_exits_before_serving(self, config_file, monkeypatch, capsys):
+        """Test that a failed roll logs an error and exits 1 without building a server"""
+        monkeypatch.setenv("STARTUP_FAILURE_RATE", "1")
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+        monkeypatch.setattr(alert_app, "build_servers", lambda *args: pytest.fail("server built after startup failure"))
+
+        alert_app.main([])
+
+        assert exits == [1]
+        assert "simulated startup failure" in capsys.readouterr().err
+
+
+class TestStartupDelay:
+    """Test STARTUP_DELAY_SECONDS"""
+
+    def _can_connect(self, port):
+        try:
+            socket.create_connection(("127.0.0.1", port), timeout=0.2).close()
+            return True
+        except OSError:
+            return False
+
+    def test_not_accepting_until_delay_elapses(self, monkeypatch):
+        """Test that the listener is only bound after the delay"""
+        monkeypatch.setenv("STARTUP_DELAY_SECONDS", "1")
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        started = time.monotonic()
+        thread = threading.Thread(target=lambda: asyncio.run(alert_app.run_server(server)), daemon=True)
+        thread.start()
+        try:
+            time.sleep(0.5)
+            assert not self._can_connect(port)
+
+            assert _wait_for(lambda: self._can_connect(port))
+            assert time.monotonic() - started >= 1.0
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+    def test_shutdown_during_delay_exits_cleanly(self, monkeypatch):
+        """Test that a shutdown request during the delay stops without ever serving"""
+        monkeypatch.setenv("STARTUP_DELAY_SECONDS", "30")
+        server = alert_app.build_server(host="127.0.0.1", port=_free_port())
+        thread = threading.Thread(target=lambda: asyncio.run(alert_app.run_server(server)), daemon=True)
+        thread.start()
+
+        time.sleep(0.2)
+        alert_app.request_shutdown(server, signal.SIGTERM)
+        thread.join(timeout=2)
+
+        assert not thread.is_alive()
+        assert not server.started
+
+
+class _FakeClock:
+    def __init__(self, now=1000.0):
+        self.now = now
+
+    def __call__(self):
+        return self.now
+
+
+class TestMinUptime:
+    """Test MIN_UPTIME_SECONDS readiness"""
+
+    @pytest.fixture
+    def clock(self, monkeypatch):
+        clock = _FakeClock()
+        monkeypatch.setattr(alert_app, "readiness", alert_app._Readiness(clock=clock))
+        return clock
+
+    def test_not_ready_before_threshold(self, client, config_file, clock, monkeypatch):
+        """Test that /readyz is 503 until the process has been up MIN_UPTIME_SECONDS"""
+        monkeypatch.setenv("MIN_UPTIME_SECONDS", "10")
+        client.get("/config")
+        clock.now += 9.5
+
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert response.text == "not ready: warming up: up 9.5s of MIN_UPTIME_SECONDS=10"
+
+    def test_ready_after_threshold(self, client, config_file, clock, monkeypatch):
+        """Test that /readyz turns 200 once the threshold has passed"""
+        monkeypatch.setenv("MIN_UPTIME_SECONDS", "10")
+        client.get("/config")
+        clock.now += 10
+
+        assert client.get("/readyz").status_code == 200
+
+    def test_failed_read_overrides_uptime(self, client, config_file, clock, monkeypatch):
+        """Test that a failed config read still reports not ready after the warm-up"""
+        monkeypatch.setenv("MIN_UPTIME_SECONDS", "10")
+        client.get("/config")
+        clock.now += 60
+        config_file.unlink()
+        client.get("/config")
+
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert "most recent config read failed" in response.text
+
+    def test_unset_means_no_warm_up(self, client, config_file, clock, monkeypatch):
+        """Test that readiness does not wait when MIN_UPTIME_SECONDS is unset"""
+        monkeypatch.delenv("MIN_UPTIME_SECONDS", raising=False)
+        client.get("/config")
+
+        assert client.get("/readyz").status_code == 200
+
+
+class TestReadinessDelay:
+    """Test the READINESS_DELAY_MIN_MS/MAX_MS randomized warm-up"""
+
+    @pytest.fixture
+    def clock(self, monkeypatch):
+        clock = _FakeClock()
+        monkeypatch.setattr(alert_app, "readiness", alert_app._Readiness(clock=clock))
+        return clock
+
+    @pytest.fixture(autouse=True)
+    def delay_range(self, monkeypatch):
+        monkeypatch.setenv("READINESS_DELAY_MIN_MS", "2000")
+        monkeypatch.setenv("READINESS_DELAY_MAX_MS", "5000")
+        monkeypatch.setenv("READINESS_SEED", "7")
+
+    def test_delay_within_range_for_seed(self):
+        """Test that a seeded delay is reproducible and inside [min, max]"""
+        delays = [alert_app.readiness_delay_seconds() for _ in range(3)]
+
+        assert 2.0 <= delays[0] <= 5.0
+        assert delays == [delays[0]] * 3
+        assert all(2.0 <= alert_app.readiness_delay_seconds(random.Random(seed)) <= 5.0 for seed in range(100))
+
+    def test_readiness_flips_after_delay(self, client, config_file, clock, capsys):
+        """Test that /readyz stays 503 until the chosen delay has passed, then turns 200"""
+        delay = alert_app.arm_readiness_delay()
+        assert f"readiness delay chosen delay_ms={round(delay * 1000)}" in capsys.readouterr().out
+        client.get("/config")
+
+        clock.now += delay - 0.01
+        response = client.get("/readyz")
+        assert response.status_code == 503
+        assert "readiness delay" in response.text
+
+        clock.now += 0.02
+        assert client.get("/readyz").status_code == 200
+
+    def test_other_conditions_still_apply(self, client, config_file, clock):
+        """Test that passing the delay alone doesn't make the app ready before a config read"""
+        delay = alert_app.arm_readiness_delay()
+        clock.now += delay + 1
+
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert "not been loaded" in response.text
+
+    def test_unset_means_no_delay(self, monkeypatch):
+        """Test that without either bound no delay is armed"""
+        monkeypatch.delenv("READINESS_DELAY_MIN_MS")
+        monkeypatch.delenv("READINESS_DELAY_MAX_MS")
+
+        assert alert_app.arm_readiness_delay() is None
+        assert alert_app.readiness.check() == (False, "config has not been loaded yet")
+
+
+class TestPprof:
+    """Test the ENABLE_PPROF debug routes"""
+
+    def test_heap_available_when_enabled(self, monkeypatch):
+        """Test that /debug/pprof/heap is served when the app starts with ENABLE_PPROF=true"""
+        monkeypatch.setenv("ENABLE_PPROF", "true")
+        module = _load_app()
+        try:
+            response = TestClient(module.app).get("/debug/pprof/heap")
+
+            assert response.status_code == 200
+            assert response.text.startswith("# traced memory:")
+        finally:
+            tracemalloc.stop()
+
+    def test_not_registered_by_default(self, client):
+        """Test that the default surface has no debug routes"""
+        assert client.get("/debug/pprof/heap").status_code == 404
+        assert client.get("/debug/pprof/").status_code == 404
+
+
+class TestHealthFlip:
+    """Test UNHEALTHY_AFTER_REQUESTS / UNHEALTHY_DURATION_SECONDS"""
+
+    def test_flips_at_threshold(self, client, config_file, monkeypatch):
+        """Test that /healthz fails once /config has been served N times"""
+        monkeypatch.setenv("UNHEALTHY_AFTER_REQUESTS", "3")
+
+        for _ in range(2):
+            client.get("/config")
+        assert client.get("/healthz").status_code == 200
+
+        client.get("/config")
+        assert client.get("/healthz").status_code == 503
+
+    def test_permanently_unhealthy_without_duration(self, monkeypatch):
+        """Test that without a cooldown the app never recovers"""
+        monkeypatch.setenv("UNHEALTHY_AFTER_REQUESTS", "1")
+        monkeypatch.delenv("UNHEALTHY_DURATION_SECONDS", raising=False)
+        clock = _FakeClock()
+        flip = alert_app._HealthFlip(clock=clock)
+
+        flip.record_config_served()
+        clock.now += 3600
+
+        assert not flip.healthy()
+
+    def test_recovery_cycle(self, monkeypatch):
+        """Test that the app recovers after the cooldown, then flips again after N more requests"""
+        monkeypatch.setenv("UNHEALTHY_AFTER_REQUESTS", "2")
+        monkeypatch.setenv("UNHEALTHY_DURATION_SECONDS", "10")
+        clock = _FakeClock()
+        flip = alert_app._HealthFlip(clock=clock)
+
+        for _ in range(2):
+            for _ in range(2):
+                flip.record_config_served()
+            assert not flip.healthy()
+            clock.now += 9
+            assert not flip.healthy()
+            clock.now += 1
+            assert flip.healthy()
+
+    def test_disabled_by_default(self, client, config_file, monkeypatch):
+        """Test that health never flips when the threshold is unset"""
+        monkeypatch.delenv("UNHEALTHY_AFTER_REQUESTS", raising=False)
+
+        for _ in range(5):
+            client.get("/config")
+
+        assert client.get("/healthz").status_code == 200
+
+
+class TestDependencyCheck:
+    """Test DEPENDENCY_URL readiness"""
+
+    def test_readiness_follows_dependency(self, client, config_file, upstream, monkeypatch):
+        """Test that /readyz tracks a dependency toggling between healthy and failing"""
+        monkeypatch.setenv("DEPENDENCY_URL", upstream.url + "/health")
+        monkeypatch.setenv("DEPENDENCY_CHECK_INTERVAL_SECONDS", "0")
+        client.get("/config")
+
+        assert client.get("/readyz").status_code == 200
+
+        upstream.status = 503
+        response = client.get("/readyz")
+        assert response.status_code == 503
+        assert "dependency returned 503" in response.text
+
+        upstream.status = 200
+        assert client.get("/readyz").status_code == 200
+
+    def test_unreachable_dependency(self, client, config_file, monkeypatch):
+        """Test that a connection failure makes the app not ready"""
+        monkeypatch.setenv("DEPENDENCY_URL", f"http://127.0.0.1:{_free_port()}/")
+        client.get("/config")
+
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert "dependency unreachable" in response.text
+
+    def test_result_is_cached(self, upstream, monkeypatch):
+        """Test that the dependency is not re-probed within the check interval"""
+        monkeypatch.setenv("DEPENDENCY_URL", upstream.url)
+        monkeypatch.setenv("DEPENDENCY_CHECK_INTERVAL_SECONDS", "30")
+        clock = _FakeClock()
+        check = alert_app._DependencyCheck(clock=clock)
+
+        check.check()
+        check.check()
+        assert len(upstream.requests) == 1
+
+        clock.now += 30
+        check.check()
+        assert len(upstream.requests) == 2
+
+    def test_no_dependency_configured(self, monkeypatch):
+        """Test that readiness ignores the dependency when DEPENDENCY_URL is unset"""
+        monkeypatch.delenv("DEPENDENCY_URL", raising=False)
+
+        assert alert_app._DependencyCheck().check() == (True, "ok")
+
+
+class TestCircuitBreaker:
+    """Test the CB_* circuit breaker around the dependency check"""
+
+    @pytest.fixture
+    def breaker(self, upstream, monkeypatch):
+        monkeypatch.setenv("DEPENDENCY_URL", upstream.url + "/health")
+        monkeypatch.setenv("DEPENDENCY_CHECK_INTERVAL_SECONDS", "0")
+        monkeypatch.setenv("CB_FAILURE_THRESHOLD", "3")
+        monkeypatch.setenv("CB_OPEN_SECONDS", "10")
+        clock = _FakeClock()
+        return alert_app._DependencyCheck(clock=clock), clock
+
+    def test_opens_after_consecutive_failures(self, upstream, breaker):
+        """Test that the threshold of consecutive failures opens the circuit"""
+        check, _ = breaker
+        upstream.status = 503
+
+        for _ in range(2):
+            assert check.check()[0] is False
+            assert check.breaker_state() == "closed"
+        check.check()
+
+        assert check.breaker_state() == "open"
+        assert len(upstream.requests) == 3
+
+    def test_success_resets_failure_count(self, upstream, breaker):
+        """Test that failures must be consecutive to open the circuit"""
+        check, _ = breaker
+        upstream.statuses = [503, 503, 200, 503, 503]
+
+        for _ in range(5):
+            check.check()
+
+        assert check.breaker_state() == "closed"
+
+    def test_open_circuit_fails_fast(self, upstream, breaker):
+        """Test that an open circuit reports not-ready without calling the dependency"""
+        check, clock = breaker
+        upstream.status = 503
+        for _ in range(3):
+            check.check()
+        upstream.status = 200
+
+        clock.now += 9
+        healthy, reason = check.check()
+
+        assert healthy is False
+        assert "circuit open" in reason
+        assert len(upstream.requests) == 3
+
+    def test_recovers_through_half_open(self, upstream, breaker, capsys):
+        """Test that one probe after CB_OPEN_SECONDS closes the circuit when it succeeds"""
+        check, clock = breaker
+        upstream.status = 503
+        for _ in range(3):
+            check.check()
+        upstream.status = 200
+
+        clock.now += 10
+
+        assert check.check() == (True, "ok")
+        assert len(upstream.requests) == 4
+        assert check.breaker_state() == "closed"
+        assert "dependency circuit closed" in capsys.readouterr().out
+
+    def test_failed_half_open_probe_reopens(self, upstream, breaker):
+        """Test that a failing half-open probe re-opens the circuit for another window"""
+        check, clock = breaker
+        upstream.status = 503
+        for _ in range(3):
+            check.check()
+
+        clock.now += 10
+        check.check()
+        assert check.breaker_state() == "open"
+        assert len(upstream.requests) == 4
+
+        clock.now += 5
+        check.check()
+        assert len(upstream.requests) == 4
+
+    def test_state_shown_on_readyz(self, client, config_file, upstream, monkeypatch):
+        """Test that /readyz reports the breaker state once the circuit opens"""
+        monkeypatch.setenv("DEPENDENCY_URL", upstream.url + "/health")
+        monkeypatch.setenv("DEPENDENCY_CHECK_INTERVAL_SECONDS", "0")
+        monkeypatch.setenv("CB_FAILURE_THRESHOLD", "1")
+        client.get("/config")
+
+        assert client.get("/readyz").text == "ok\n# dependency circuit breaker: closed\n"
+
+        upstream.status = 503
+        client.get("/readyz")
+        response = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert response.text.startswith("not ready: dependency circuit open")
+        assert response.text.endswith("# dependency circuit breaker: open\n")
+
+
+class TestJsonNegotiation:
+    """Test Accept: application/json on /config"""
+
+    def test_yaml_converted_to_json(self, client, config_file):
+        """Test that valid YAML is served as JSON when the client asks for it"""
+        config_file.write_text("message: all good\nreplicas: 3\n")
+
+        response = client.get("/config", headers={"Accept": "application/json"})
+
+        assert response.status_code == 200
+        assert response.headers["content-type"] == "application/json"
+        assert response.json() == {"message": "all good", "replicas": 3}
+
+    def test_raw_text_without_json_accept(self, client, config_file):
+        """Test that other clients still get the raw text"""
+        response = client.get("/config", headers={"Accept": "text/plain"})
+
+        assert response.headers["content-type"].startswith("text/plain")
+        assert response.text == 'message: "all good"\n'
+
+    def test_invalid_yaml_falls_back_to_raw_text(self, client, config_file):
+        """Test that unparseable YAML is served as-is rather than failing"""
+        config_file.write_text("message: [unterminated\n")
+
+        response = client.get("/config", headers={"Accept": "application/json"})
+
+        assert response.status_code == 200
+        assert response.text == "message: [unterminated\n"
+
+    def test_timestamps_become_iso_strings(self, client, config_file):
+        """Test that YAML dates and datetimes are served as ISO 8601 strings"""
+        config_file.write_text("deployed: 2024-01-01\nat: 2024-01-01 12:30:00\n")
+
+        response = client.get("/config", headers={"Accept": "application/json"})
+
+        assert response.status_code == 200
+        assert response.json() == {"deployed": "2024-01-01", "at": "2024-01-01T12:30:00"}
+
+    def test_conversion_error_returns_500(self, client, config_file):
+        """Test that YAML values without a JSON equivalent produce a 500"""
+        config_file.write_text("blob: !!binary aGVsbG8=\n")
+
+        response = client.get("/config", headers={"Accept": "application/json"})
+
+        assert response.status_code == 500
+        assert "failed to convert config to JSON" in response.text
+
+
+class TestErrorEnvelope:
+    """Test the JSON error envelope and its plain-text fallback"""
+
+    def test_json_envelope_for_read_failure(self, client, tmp_path, monkeypatch):
+        """Test that JSON clients get code, message and request_id"""
+        monkeypatch.setenv("CONFIG_PATH", str(tmp_path / "missing.yaml"))
+
+        response = client.get("/config", headers={"Accept": "application/json", "X-Request-ID": "req-42"})
+
+        assert response.status_code == 500
+        assert response.json() == {
+            "error": {"code": "config_read_failed", "message": "failed to read config", "request_id": "req-42"}
+        }
+
+    def test_json_envelope_for_crash(self, client, config_file, monkeypatch):
+        """Test that the crash path uses the envelope too"""
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", lambda: None)
+        config_file.write_text('message: "Crash"\n')
+
+        response = client.get("/config", headers={"Accept": "application/json"})
+
+        assert response.status_code == 500
+        error = response.json()["error"]
+        assert error["code"] == "crash_triggered"
+        assert error["message"] == "config triggered crash"
+        assert error["request_id"] == response.headers["x-request-id"]
+
+    def test_json_envelope_for_slow_read_failure(self, client, tmp_path, monkeypatch):
+        """Test that /slow reports a failed config read with the envelope"""
+        monkeypatch.setenv("CONFIG_PATH", str(tmp_path / "missing.yaml"))
+
+        response = client.get("/slow", headers={"Accept": "application/json"})
+
+        assert response.status_code == 500
+        assert response.json()["error"]["code"] == "config_read_failed"
+
+    def test_json_envelope_for_watch_read_failure(self, client, config_file):
+        """Test that /config/watch reports a config removed mid-poll with the envelope"""
+        with concurrent.futures.ThreadPoolExecutor() as pool:
+            poll = pool.submit(client.get, "/config/watch?timeout=5", headers={"Accept": "application/json"})
+            time.sleep(0.3)
+            config_file.unlink()
+
+            response = poll.result(timeout=5)
+
+        assert response.status_code == 500
+        assert response.json()["error"]["code"] == "config_read_failed"
+
+    def test_json_envelope_for_recovered_panic(self, client, monkeypatch):
+        """Test that a recovered panic's 500 carries the envelope and the request ID"""
+        monkeypatch.delenv("RECOVER_PANICS", raising=False)
+
+        response = client.get("/panic", headers={"Accept": "application/json", "X-Request-ID": "req-7"})
+
+        assert response.status_code == 500
+        assert response.json() == {
+            "error": {"code": "internal_error", "message": "internal server error", "request_id": "req-7"}
+        }
+
+    @pytest.mark.parametrize(
+        "target,code",
+        [
+            ("/burn?duration_ms=soon", "invalid_burn"),
+            ("/burn?workers=0", "invalid_burn"),
+            ("/sleep?ms=later", "invalid_sleep"),
+            ("/sleep?ms=-1", "invalid_sleep"),
+        ],
+    )
+    def test_json_envelope_for_invalid_parameters(self, client, target, code):
+        """Test that rejected query parameters are reported with the envelope"""
+        response = client.get(target, headers={"Accept": "application/json"})
+
+        assert response.status_code == 400
+        assert response.json()["error"]["code"] == code
+
+    def test_json_envelope_for_oversized_echo(self, client, monkeypatch):
+        """Test that an /echo body over MAX_CONFIG_BYTES is a 413 with the envelope"""
+        monkeypatch.setenv("MAX_CONFIG_BYTES", "4")
+
+        response = client.post("/echo", content=b"too long", headers={"Accept": "application/json"})
+
+        assert response.status_code == 413
+        assert response.json()["error"] == {
+            "code": "body_too_large",
+            "message": "request body too large",
+            "request_id": response.headers["x-request-id"],
+        }
+
+    def test_plain_text_fallback(self, client, tmp_path, monkeypatch):
+        """Test that other clients keep the plain-text message"""
+        monkeypatch.setenv("CONFIG_PATH", str(tmp_path / "missing.yaml"))
+
+        response = client.get("/config")
+
+        assert response.status_code == 500
+        assert response.headers["content-type"].startswith("text/plain")
+        assert response.text == "failed to read config"
+
+
+class TestBasicAuth:
+    """Test BASIC_AUTH_USER / BASIC_AUTH_PASS on /config"""
+
+    @pytest.fixture
+    def auth_enabled(self, monkeypatch):
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+    def test_missing_credentials(self, client, config_file, auth_enabled):
+        """Test that a request without credentials gets a 401 challenge"""
+        response = client.get("/config")
+
+        assert response.status_code == 401
+        assert response.headers["WWW-Authenticate"].startswith("Basic")
+
+    def test_wrong_credentials(self, client, config_file, auth_enabled):
+        """Test that a wrong password is rejected"""
+        assert client.get("/config", auth=("admin", "guess")).status_code == 401
+
+    def test_correct_credentials(self, client, config_file, auth_enabled):
+        """Test that matching credentials get the config"""
+        response = client.get("/config", auth=("admin", "s3cret"))
+
+        assert response.status_code == 200
+        assert response.text == 'message: "all good"\n'
+
+    def test_probes_stay_unauthenticated(self, client, config_file, auth_enabled):
+        """Test that liveness and readiness probes never require credentials"""
+        assert client.get("/healthz").status_code == 200
+        assert client.get("/readyz").status_code in (200, 503)
+        assert client.get("/readyz").status_code != 401
+
+    def test_disabled_by_default(self, client, config_file, monkeypatch):
+        """Test that /config is open when credentials are not configured"""
+        monkeypatch.delenv("BASIC_AUTH_USER", raising=False)
+        monkeypatch.delenv("BASIC_AUTH_PASS", raising=False)
+
+        assert client.get("/config").status_code == 200
+
+
+class TestRateLimit:
+    """Test the per-client /config rate limiter"""
+
+    def test_fast_traffic_gets_429(self, client, config_file, monkeypatch):
+        """Test that bursting past the limit yields 429 with Retry-After"""
+        monkeypatch.setenv("RATE_LIMIT_RPS", "1")
+        monkeypatch.setenv("RATE_LIMIT_BURST", "2")
+
+        statuses = [client.get("/config").status_code for _ in range(5)]
+
+        assert statuses[:2] == [200, 200]
+        assert 429 in statuses[2:]
+        limited = client.get("/config")
+        assert limited.status_code == 429
+        assert int(limited.headers["Retry-After"]) >= 1
+
+    def test_limit_is_per_client_ip(self, client, config_file, monkeypatch):
+        """Test that X-Forwarded-For clients get their own buckets"""
+        monkeypatch.setenv("RATE_LIMIT_RPS", "1")
+        monkeypatch.setenv("RATE_LIMIT_BURST", "1")
+
+        assert client.get("/config", headers={"X-Forwarded-For": "10.0.0.1"}).status_code == 200
+        assert client.get("/config", headers={"X-Forwarded-For": "10.0.0.1"}).status_code == 429
+        assert client.get("/config", headers={"X-Forwarded-For": "10.0.0.2, 10.0.0.9"}).status_code == 200
+
+    def test_slow_traffic_never_limited(self, monkeypatch):
+        """Test that requests spaced slower than the rate are always allowed"""
+        monkeypatch.setenv("RATE_LIMIT_RPS", "2")
+        monkeypatch.setenv("RATE_LIMIT_BURST", "1")
+        clock = _FakeClock()
+        limiter = alert_app._RateLimiter(clock=clock)
+
+        for _ in range(20):
+            assert limiter.allow("10.0.0.1") == (True, 0)
+            clock.now += 0.5
+
+    def test_other_endpoints_unlimited(self, client, monkeypatch):
+        """Test that only /config is rate limited"""
+        monkeypatch.setenv("RATE_LIMIT_RPS", "1")
+        monkeypatch.setenv("RATE_LIMIT_BURST", "1")
+
+        assert all(client.get("/healthz").status_code == 200 for _ in range(5))
+
+
+class TestResponseHeaders:
+    """Test RESPONSE_HEADERS injection on /config"""
+
+    def test_headers_applied(self, client, config_file, monkeypatch):
+        """Test that parsed headers are set on /config responses only"""
+        monkeypatch.setattr(
+            alert_app, "response_headers", alert_app.parse_response_headers("X-Route:canary, X-Team : sre")
+        )
+
+        response = client.get("/config")
+
+        assert response.headers["X-Route"] == "canary"
+        assert response.headers["X-Team"] == "sre"
+        assert "X-Route" not in client.get("/healthz").headers
+
+    def test_headers_on_error_responses(self, client, config_file, monkeypatch):
+        """Test that the headers are also set when /config fails"""
+        monkeypatch.setattr(alert_app, "response_headers", {"X-Route": "canary"})
+        monkeypatch.setenv("CONFIG_PATH", "/nonexistent/config.yaml")
+
+        response = client.get("/config")
+
+        assert response.status_code == 500
+        assert response.headers["X-Route"] == "canary"
+
+    def test_value_may_contain_colon(self):
+        """Test that only the first colon separates name from value"""
+        assert alert_app.parse_response_headers("X-Upstream:http://backend:8080") == {
+            "X-Upstream": "http://backend:8080"
+        }
+
+    def test_malformed_pairs_skipped(self, capsys):
+        """Test that pairs without a colon or with an invalid name are skipped with a warning"""
+        headers = alert_app.parse_response_headers("X-Good:yes,novalue,Bad Name:x,:empty")
+
+        assert headers == {"X-Good": "yes"}
+        logged = capsys.readouterr().err
+        assert "'novalue'" in logged
+        assert "'Bad Name:x'" in logged
+        assert "':empty'" in logged
+
+    def test_reserved_headers_not_overridable(self, client, config_file, monkeypatch, capsys):
+        """Test that framing and content headers are dropped at parse time"""
+        headers = alert_app.parse_response_headers("Content-Length:1,content-type:text/html,X-Ok:1")
+        monkeypatch.setattr(alert_app, "response_headers", headers)
+
+        response = client.get("/config")
+
+        assert headers == {"X-Ok": "1"}
+        assert "reserved header Content-Length" in capsys.readouterr().err
+        assert response.headers["content-type"].startswith("text/plain")
+        assert response.text == 'message: "all good"\n'
+
+    def test_parsed_at_startup(self, config_file, monkeypatch):
+        """Test that main() parses RESPONSE_HEADERS once before serving"""
+        monkeypatch.setenv("RESPONSE_HEADERS", "X-Route:canary")
+        monkeypatch.setattr(alert_app, "build_servers", lambda *args: [])
+        monkeypatch.setattr(alert_app, "_serve", lambda *servers: asyncio.sleep(0))
+
+        alert_app.main([])
+
+        assert alert_app.response_headers == {"X-Route": "canary"}
+
+
+class TestGzip:
+    """Test gzip compression of /config"""
+
+    LARGE = "message: " + "x" * 4096 + "\n"
+
+    def test_large_body_compressed(self, client, config_file):
+        """Test that a large body is gzipped and decodes to the original"""
+        config_file.write_text(self.LARGE)
+
+        response = client.get("/config", headers={"Accept-Encoding": "gzip"})
+
+        assert response.headers["content-encoding"] == "gzip"
+        assert int(response.headers["content-length"]) < len(self.LARGE)
+        assert response.text == self.LARGE
+
+    def test_small_body_not_compressed(self, client, config_file):
+        """Test that bodies under 1KB are sent plain"""
+        response = client.get("/config", headers={"Accept-Encoding": "gzip"})
+
+        assert "content-encoding" not in response.headers
+        assert response.text == 'message: "all good"\n'
+
+    def test_client_without_gzip_gets_plain_body(self, client, config_file):
+        """Test that clients not advertising gzip get an uncompressed body"""
+        config_file.write_text(self.LARGE)
+
+        response = client.get("/config", headers={"Accept-Encoding": "identity"})
+
+        assert "content-encoding" not in response.headers
+        assert response.headers["vary"] == "Accept-Encoding"
+        assert response.text == self.LARGE
+
+    def test_accept_encoding_parsing(self):
+        """Test that q=0 opts out and other codings don't count"""
+        def accepts(value):
+            return alert_app._accepts_gzip(_FakeRequest(headers={"accept-encoding": value}))
+
+        assert accepts("gzip, deflate")
+        assert accepts("br;q=1.0, gzip;q=0.5")
+        assert not accepts("gzip;q=0")
+        assert not accepts("deflate, br")
+
+
+ACCESS_LOG_RE = re.compile(
+    r'^(?P<host>\S+) - - \[(?P<time>[^\]]+)\] "(?P<method>\S+) (?P<target>\S+) HTTP/(?P<version>[\d.]+)" '
+    r'(?P<status>\d{3}) (?P<bytes>\d+|-) "(?P<referer>[^"]*)" "(?P<agent>[^"]*)" (?P<duration>[\d.]+)$'
+)
+
+
+class TestETag:
+    """Test ETag and conditional GET on /config"""
+
+    def test_initial_response_has_etag(self, client, config_file):
+        """Test that a 200 carries the SHA-256 of the body as its ETag"""
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        digest = hashlib.sha256(b'message: "all good"\n').hexdigest()
+        assert response.headers["etag"] == f'"{digest}"'
+
+    def test_matching_condition_returns_304(self, client, config_file):
+        """Test that If-None-Match with the current ETag yields an empty 304"""
+        etag = client.get("/config").headers["etag"]
+
+        response = client.get("/config", headers={"If-None-Match": etag})
+
+        assert response.status_code == 304
+        assert response.content == b""
+        assert response.headers["etag"] == etag
+
+    def test_mismatched_condition_returns_200(self, client, config_file):
+        """Test that a stale ETag gets the full body, with a new tag after the file changes"""
+        etag = client.get("/config").headers["etag"]
+        config_file.write_text('message: "changed"\n')
+
+        response = client.get("/config", headers={"If-None-Match": etag})
+
+        assert response.status_code == 200
+        assert response.text == 'message: "changed"\n'
+        assert response.headers["etag"] != etag
+
+
+class TestChunked:
+    """Test ?chunked=true on /config"""
+
+    def test_chunked_without_content_length(self, config_file, monkeypatch):
+        """Test that a real server sends the body chunked, with no Content-Length, and it reassembles"""
+        monkeypatch.setenv("CHUNK_SIZE_BYTES", "4")
+        body = "message: " + "x" * 40 + "\n"
+        config_file.write_text(body)
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        try:
+            response = httpx.get(f"http://127.0.0.1:{port}/config?chunked=true")
+        finally:
+            server.should_exit = True
+            thread.join(timeout=10)
+
+        assert response.status_code == 200
+        assert "content-length" not in response.headers
+        assert response.headers["transfer-encoding"] == "chunked"
+        assert response.text == body
+
+    def test_chunks_follow_chunk_size(self):
+        """Test that the streamed pieces are chunk_size long and keep status and headers"""
+        original = alert_app.Response(content=b"0123456789", status_code=203, headers={"ETag": '"abc"'})
+
+        response = alert_app.chunked_response(original, 4)
+
+        async def consume():
+            return [chunk async for chunk in response.body_iterator]
+
+        assert response.status_code == 203
+        assert response.headers["etag"] == '"abc"'
+        assert asyncio.run(consume()) == [b"0123", b"4567", b"89"]
+
+    def test_crash_armed_chunked_still_exits(self, client, config_file, monkeypatch):
+        """Test that chunking a crash-armed 500 keeps the scheduled crash exit"""
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+        monkeypatch.setenv("CRASH_DELAY_MS", "0")
+        config_file.write_text('message: "Crash"\n')
+
+        response = client.get("/config?chunked=true")
+
+        assert response.status_code == 500
+        assert "content-length" not in response.headers
+        assert exits == [1]
+
+    def test_default_keeps_content_length(self, client, config_file):
+        """Test that responses are buffered with a Content-Length unless chunking is asked for"""
+        response = client.get("/config")
+
+        assert response.headers["content-length"] == str(len('message: "all good"\n'))
+
+
+class TestCors:
+    """Test the CORS_ALLOW_ORIGINS policy"""
+
+    DASHBOARD = "https://dashboard.example.com"
+
+    @pytest.fixture(autouse=True)
+    def allow_dashboard(self, monkeypatch):
+        monkeypatch.setenv("CORS_ALLOW_ORIGINS", f"{self.DASHBOARD}, https://other.example.com")
+
+    def test_preflight(self, client):
+        """Test that a preflight from an allowed origin gets the Allow-* headers"""
+        response = client.options(
+            "/config",
+            headers={
+                "Origin": self.DASHBOARD,
+                "Access-Control-Request-Method": "GET",
+                "Access-Control-Request-Headers": "authorization",
+            },
+        )
+
+        assert response.status_code == 204
+        assert response.headers["access-control-allow-origin"] == self.DASHBOARD
+        assert "GET" in response.headers["access-control-allow-methods"]
+        assert response.headers["access-control-allow-headers"] == "authorization"
+
+    def test_allowed_origin_echoed(self, client, config_file):
+        """Test that a simple request from an allowed origin has its origin echoed"""
+        response = client.get("/config", headers={"Origin": self.DASHBOARD})
+
+        assert response.status_code == 200
+        assert response.headers["access-control-allow-origin"] == self.DASHBOARD
+        assert "Origin" in response.headers["vary"]
+
+    def test_disallowed_origin(self, client, config_file):
+        """Test that other origins get no CORS headers and a rejected preflight"""
+        response = client.get("/config", headers={"Origin": "https://evil.example.com"})
+        assert response.status_code == 200
+        assert "access-control-allow-origin" not in response.headers
+
+        preflight = client.options(
+            "/config", headers={"Origin": "https://evil.example.com", "Access-Control-Request-Method": "GET"}
+        )
+        assert preflight.status_code == 403
+        assert "access-control-allow-origin" not in preflight.headers
+
+    def test_unset_adds_no_headers(self, client, config_file, monkeypatch):
+        """Test that without CORS_ALLOW_ORIGINS responses are unchanged"""
+        monkeypatch.delenv("CORS_ALLOW_ORIGINS")
+
+        response = client.get("/config", headers={"Origin": self.DASHBOARD})
+
+        assert "access-control-allow-origin" not in response.headers
+
+
+class TestMethodGuard:
+    """Test the per-route method allowlist"""
+
+    def test_allowed_method(self, client, config_file):
+        """Test that GET on /config and POST on an admin route are served"""
+        assert client.get("/config").status_code == 200
+        assert client.post("/admin/drain").status_code == 200
+
+    def test_disallowed_method_is_405_with_allow(self, client, config_file):
+        """Test that other methods get a 405 naming the accepted ones"""
+        for method, path, allow in (
+            ("POST", "/config", "GET, HEAD"),
+            ("DELETE", "/config", "GET, HEAD"),
+            ("PUT", "/healthz", "GET, HEAD"),
+            ("GET", "/admin/drain", "POST"),
+            ("GET", "/config/reload", "POST"),
+            ("GET", "/config/validate", "POST"),
+            ("GET", "/config/diff", "POST"),
+        ):
+            response = client.request(method, path)
+
+            assert response.status_code == 405, (method, path)
+            assert response.headers["allow"] == allow
+
+    def test_disallowed_method_not_counted(self, client, config_file):
+        """Test that rejected methods don't reach the request metrics"""
+        client.delete("/config")
+
+        assert 'alert_example_requests_total{path="/config"}' not in client.get("/metrics").text
+
+    def test_head_returns_headers_without_body(self, client, config_file):
+        """Test that HEAD gets the GET headers, including Content-Length, but no body"""
+        get = client.get("/config")
+        head = client.head("/config")
+
+        assert head.status_code == 200
+        assert head.content == b""
+        assert head.headers["content-length"] == get.headers["content-length"]
+        assert head.headers["content-type"] == get.headers["content-type"]
+        assert client.head("/healthz").status_code == 200
+
+
+class TestAccessLog:
+    """Test the ACCESS_LOG combined-format access log"""
+
+    def test_line_parses_into_expected_fields(self, client, config_file, monkeypatch, capsys):
+        """Test that a request produces a combined log line with the right fields"""
+        monkeypatch.setenv("ACCESS_LOG", "true")
+
+        response = client.get("/config?x=1", headers={"Referer": "http://dash/", "User-Agent": "probe/1.0"})
+
+        lines = [line for line in capsys.readouterr().out.splitlines() if ACCESS_LOG_RE.match(line)]
+        assert len(lines) == 1
+        fields = ACCESS_LOG_RE.match(lines[0]).groupdict()
+        assert fields["method"] == "GET"
+        assert fields["target"] == "/config?x=1"
+        assert fields["status"] == "200"
+        assert int(fields["bytes"]) == len(response.content)
+        assert fields["referer"] == "http://dash/"
+        assert fields["agent"] == "probe/1.0"
+        assert float(fields["duration"]) >= 0
+
+    def test_disabled_by_default(self, client, monkeypatch, capsys):
+        """Test that nothing is written without ACCESS_LOG"""
+        monkeypatch.delenv("ACCESS_LOG", raising=False)
+
+        client.get("/healthz")
+
+        assert not any(ACCESS_LOG_RE.match(line) for line in capsys.readouterr().out.splitlines())
+
+
+class TestBodyLog:
+    """Test LOG_REQUEST_BODY / LOG_RESPONSE_BODY body logging"""
+
+    @pytest.fixture(autouse=True)
+    def json_logs(self, monkeypatch):
+        monkeypatch.setenv("LOG_FORMAT", "json")
+
+    @staticmethod
+    def _events(capsys, msg):
+        lines = [line for line in capsys.readouterr().out.splitlines() if line.startswith("{")]
+        return [event for event in map(json.loads, lines) if event["msg"] == msg]
+
+    def test_request_body_logged(self, client, monkeypatch, capsys):
+        """Test that an /echo request body is logged with its size"""
+        monkeypatch.setenv("LOG_REQUEST_BODY", "true")
+
+        client.post("/echo", content=b'{"alert": "firing"}')
+
+        [event] = self._events(capsys, "request body")
+        assert event["path"] == "/echo"
+        assert event["method"] == "POST"
+        assert event["body"] == '{"alert": "firing"}'
+        assert event["bytes"] == 19
+        assert event["truncated"] is False
+
+    def test_response_body_logged(self, client, config_file, monkeypatch, capsys):
+        """Test that the /config response body is logged on one line"""
+        monkeypatch.setenv("LOG_RESPONSE_BODY", "true")
+
+        client.get("/config")
+
+        [event] = self._events(capsys, "response body")
+        assert event["status"] == 200
+        assert event["body"] == 'message: "all good"\\n'
+
+    def test_body_lines_carry_request_id(self, client, config_file, monkeypatch, capsys):
+        """Test that request and response body lines carry the request's X-Request-ID"""
+        monkeypatch.setenv("LOG_REQUEST_BODY", "true")
+        monkeypatch.setenv("LOG_RESPONSE_BODY", "true")
+
+        response = client.post("/echo", content=b"ping", headers={"X-Request-ID": "req-body-1"})
+
+        assert response.headers["x-request-id"] == "req-body-1"
+        events = [json.loads(line) for line in capsys.readouterr().out.splitlines() if line.startswith("{")]
+        body_events = [event for event in events if event["msg"] in ("request body", "response body")]
+        assert [event["request_id"] for event in body_events] == ["req-body-1", "req-body-1"]
+
+    def test_truncated_at_cap(self, client, config_file, monkeypatch, capsys):
+        """Test that bodies are cut at LOG_BODY_MAX_BYTES and flagged as truncated"""
+        monkeypatch.setenv("LOG_RESPONSE_BODY", "true")
+        monkeypatch.setenv("LOG_BODY_MAX_BYTES", "7")
+
+        client.get("/config")
+
+        [event] = self._events(capsys, "response body")
+        assert event["body"] == "message"
+        assert event["truncated"] is True
+        assert event["bytes"] == len('message: "all good"\n')
+
+    def test_redaction(self, client, monkeypatch, capsys):
+        """Test that LOG_REDACT substrings are masked, including one crossing the cap"""
+        monkeypatch.setenv("LOG_REQUEST_BODY", "true")
+        monkeypatch.setenv("LOG_REDACT", "s3cret,tok-123")
+        monkeypatch.setenv("LOG_BODY_MAX_BYTES", "20")
+
+        client.post("/echo", content=b"password=s3cret&api=tok-123")
+
+        [event] = self._events(capsys, "request body")
+        assert "s3cret" not in event["body"]
+        assert "tok-" not in event["body"]
+        assert event["body"] == "password=***&api=***"
+
+    def test_binary_safe(self, client, monkeypatch, capsys):
+        """Test that undecodable bytes and control characters are escaped"""
+        monkeypatch.setenv("LOG_REQUEST_BODY", "true")
+
+        client.post("/echo", content=b"\xff\x00ok\r\n")
+
+        [event] = self._events(capsys, "request body")
+        assert event["body"] == "\\xff\\x00ok\\r\\n"
+
+    def test_disabled_by_default_and_other_paths(self, client, config_file, monkeypatch, capsys):
+        """Test that nothing is logged unless enabled, and only /config and /echo are logged"""
+        monkeypatch.delenv("LOG_REQUEST_BODY", raising=False)
+        monkeypatch.delenv("LOG_RESPONSE_BODY", raising=False)
+        client.get("/config")
+        assert self._events(capsys, "response body") == []
+
+        monkeypatch.setenv("LOG_RESPONSE_BODY", "true")
+        client.get("/healthz")
+        assert self._events(capsys, "response body") == []
+
+
+class TestValidate:
+    """Test POST /config/validate"""
+
+    @pytest.fixture(autouse=True)
+    def no_crash(self, monkeypatch):
+        def fail():
+            raise AssertionError("validate must never crash")
+
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", fail)
+
+    def test_crash_armed_body(self, client, monkeypatch):
+        """Test that a candidate containing keywords reports which ones matched"""
+        monkeypatch.setenv("CRASH_KEYWORD", "Crash,Boom,Kaput")
+
+        response = client.post("/config/validate", content='message: "Crash and Boom"\n')
+
+        assert response.json() == {"crash_armed": True, "keywords_matched": ["Crash", "Boom"]}
+
+    def test_clean_body(self, client):
+        """Test that a clean candidate is not crash-armed"""
+        response = client.post("/config/validate", content='message: "all good"\n')
+
+        assert response.json() == {"crash_armed": False, "keywords_matched": []}
+
+    def test_empty_body(self, client):
+        """Test that an empty candidate is not crash-armed"""
+        response = client.post("/config/validate", content=b"")
+
+        assert response.status_code == 200
+        assert response.json() == {"crash_armed": False, "keywords_matched": []}
+
+    def test_crash_armed_scenario(self, client, monkeypatch):
+        """Test that CRASH_ARMED arms any candidate, as it would on /config"""
+        monkeypatch.setenv("CRASH_ARMED", "true")
+
+        response = client.post("/config/validate", content='message: "all good"\n')
+
+        assert response.json() == {"crash_armed": True, "keywords_matched": []}
+
+
+class TestConfigDiff:
+    """Test POST /config/diff"""
+
+    @pytest.fixture(autouse=True)
+    def no_crash(self, monkeypatch):
+        def fail():
+            raise AssertionError("diff must never crash")
+
+        monkeypatch.setattr(alert_app, "schedule_crash_exit", fail)
+
+    def test_identical_candidate(self, client, config_file):
+        """Test that the current config diffs empty against itself"""
+        response = client.post("/config/diff", content='message: "all good"\n')
+
+        assert response.status_code == 200
+        assert response.json() == {"changed": False, "diff": "", "crash_armed": False, "keywords_matched": []}
+
+    def test_changed_candidate(self, client, config_file):
+        """Test that a changed line shows up as a unified diff hunk"""
+        response = client.post("/config/diff", content='message: "all better"\nlevel: info\n')
+
+        body = response.json()
+        assert body["changed"] is True
+        assert body["diff"].splitlines() == [
+            "--- current",
+            "+++ candidate",
+            "@@ -1 +1,2 @@",
+            '-message: "all good"',
+            '+message: "all better"',
+            "+level: info",
+        ]
+        assert body["crash_armed"] is False
+
+    def test_crash_arming_candidate(self, client, config_file, monkeypatch):
+        """Test that a candidate adding the crash keyword is flagged without crashing"""
+        monkeypatch.setenv("CRASH_KEYWORD", "Crash")
+
+        response = client.post("/config/diff", content='message: "Crash"\n')
+
+        body = response.json()
+        assert body["changed"] is True
+        assert '+message: "Crash"' in body["diff"].splitlines()
+        assert body["crash_armed"] is True
+        assert body["keywords_matched"] == ["Crash"]
+
+    def test_crash_armed_scenario(self, client, config_file, monkeypatch):
+        """Test that SCENARIO=crashloop flags a keyword-free candidate"""
+        monkeypatch.setenv("SCENARIO", "crashloop")
+
+        body = client.post("/config/diff", content='message: "all good"\n').json()
+
+        assert body["crash_armed"] is True
+        assert body["keywords_matched"] == []
+
+    def test_unreadable_config(self, client, config_file):
+        """Test that a missing current config is a 500"""
+        config_file.unlink()
+
+        assert client.post("/config/diff", content=b"x\n").status_code == 500
+
+    def test_requires_credentials(self, client, config_file, monkeypatch):
+        """Test that configured basic auth keeps the current config out of the diff"""
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+        response = client.post("/config/diff", content=b"")
+
+        assert response.status_code == 401
+        assert "all good" not in response.text
+        assert client.post("/config/diff", content=b"", auth=("admin", "s3cret")).json()["changed"] is True
+
+
+class TestScenario:
+    """Test SCENARIO presets and their per-field overrides"""
+
+    @pytest.fixture(autouse=True)
+    def clear_fields(self, monkeypatch):
+        for name in ("RESPONSE_DELAY_MS", "ERROR_RATE", "CRASH_ARMED"):
+            monkeypatch.delenv(name, raising=False)
+
+    @pytest.mark.parametrize(
+        "scenario,delay_ms,error_rate,crash_armed",
+        [
+            ("healthy", 0, 0.0, False),
+            ("crashloop", 0, 0.0, True),
+            ("slow", 2000, 0.0, False),
+            ("flaky", 0, 0.3, False),
+        ],
+    )
+    def test_named_scenarios(self, scenario, delay_ms, error_rate, crash_armed, monkeypatch):
+        """Test that each scenario resolves to its documented values"""
+        monkeypatch.setenv("SCENARIO", scenario)
+
+        assert alert_app.get_response_delay_ms() == delay_ms
+        assert alert_app.get_error_rate() == error_rate
+        assert alert_app.should_crash('message: "all good"\n') is crash_armed
+
+    def test_explicit_env_overrides_field(self, monkeypatch):
+        """Test that an explicitly set variable wins over the scenario's value"""
+        monkeypatch.setenv("SCENARIO", "flaky")
+        monkeypatch.setenv("ERROR_RATE", "0.9")
+
+        assert alert_app.get_error_rate() == 0.9
+        # Fields without an override keep the scenario's value
+        assert alert_app.get_response_delay_ms() == 0
+
+        monkeypatch.setenv("SCENARIO", "crashloop")
+        monkeypatch.setenv("CRASH_ARMED", "false")
+        assert not alert_app.should_crash('message: "all good"\n')
+
+    def test_unset_or_unknown_uses_defaults(self, monkeypatch):
+        """Test that no scenario, or an unknown one, leaves the plain defaults"""
+        for scenario in ("", "chaos"):
+            monkeypatch.setenv("SCENARIO", scenario)
+            assert alert_app.get_response_delay_ms() == 0
+            assert alert_app.get_error_rate() == 0.0
+            assert not alert_app.should_crash('message: "all good"\n')
+
+
+class TestResponseTemplate:
+    """Test RESPONSE_TEMPLATE rendering on /config"""
+
+    def test_renders_fields(self, client, monkeypatch):
+        """Test that the template is served with its fields substituted"""
+        monkeypatch.setenv("RESPONSE_TEMPLATE", "host={{.Hostname}} n={{ .RequestCount }} at={{.Now}}")
+        monkeypatch.delenv("CONFIG_PATH", raising=False)
+
+        first = client.get("/config")
+        second = client.get("/config")
+
+        assert first.status_code == 200
+        assert first.text.startswith(f"host={socket.gethostname()} n=1 at=")
+        assert re.search(r"at=\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ$", first.text)
+        assert " n=2 " in second.text
+
+    def test_parse_error_returns_500(self, client, monkeypatch):
+        """Test that malformed actions and unknown fields are a 500"""
+        for template in ("{{.Now", "{{.Missing}}", "{{ Now }}"):
+            monkeypatch.setenv("RESPONSE_TEMPLATE", template)
+
+            response = client.get("/config")
+
+            assert response.status_code == 500
+            assert response.text.startswith("failed to render template: ")
+
+    def test_unset_falls_back_to_file(self, client, config_file, monkeypatch):
+        """Test that the config file is served when no template is set"""
+        monkeypatch.delenv("RESPONSE_TEMPLATE", raising=False)
+
+        assert client.get("/config").text == 'message: "all good"\n'
+
+
+class TestConcurrencyLimit:
+    """Test MAX_CONCURRENT_REQUESTS on /config"""
+
+    @pytest.fixture(autouse=True)
+    def slow_config(self, config_file, monkeypatch):
+        monkeypatch.setenv("MAX_CONCURRENT_REQUESTS", "1")
+        monkeypatch.setenv("RESPONSE_DELAY_MS", "300")
+
+    def test_rejects_when_full(self, client, monkeypatch):
+        """Test that OVERFLOW_REJECT=true answers 503 while the only slot is taken"""
+        monkeypatch.setenv("OVERFLOW_REJECT", "true")
+
+        with concurrent.futures.ThreadPoolExecutor() as pool:
+            first = pool.submit(client.get, "/config")
+            assert _wait_for(lambda: alert_app.concurrency.in_flight() == 1)
+
+            rejected = client.get("/config")
+
+            assert rejected.status_code == 503
+            assert rejected.text == "too many concurrent requests"
+            assert first.result().status_code == 200
+
+    def test_blocks_when_full(self, client):
+        """Test that by default a second request waits for the slot instead of failing"""
+        started = time.monotonic()
+        with concurrent.futures.ThreadPoolExecutor() as pool:
+            responses = list(pool.map(lambda _: client.get("/config"), range(2)))
+        elapsed = time.monotonic() - started
+
+        assert [r.status_code for r in responses] == [200, 200]
+        # Serialised by the single slot: two delays back to back
+        assert elapsed >= 0.6
+
+    def test_slots_released_after_completion(self, client, monkeypatch):
+        """Test that slots are returned once requests finish, including failed ones"""
+        monkeypatch.setenv("OVERFLOW_REJECT", "true")
+        monkeypatch.setenv("RESPONSE_DELAY_MS", "0")
+
+        for _ in range(3):
+            assert client.get("/config").status_code == 200
+        monkeypatch.setenv("ERROR_RATE", "1")
+        assert client.get("/config").status_code == 500
+
+        assert alert_app.concurrency.in_flight() == 0
+
+
+class TestLoadShedding:
+    """Test MAX_GOROUTINES load shedding on /config"""
+
+    def test_sheds_while_threads_parked(self, client, config_file, monkeypatch):
+        """Test that /config is 503 with Retry-After above the limit and recovers once threads exit"""
+        monkeypatch.setenv("MAX_GOROUTINES", str(threading.active_count() + 5))
+        release = threading.Event()
+        parked = [threading.Thread(target=release.wait, daemon=True) for _ in range(10)]
+        for thread in parked:
+            thread.start()
+        try:
+            response = client.get("/config")
+
+            assert response.status_code == 503
+            assert response.headers["Retry-After"] == str(alert_app.SHED_RETRY_AFTER_SECONDS)
+            assert response.text == "server overloaded"
+        finally:
+            release.set()
+            for thread in parked:
+                thread.join()
+
+        assert client.get("/config").status_code == 200
+
+    def test_disabled_by_default(self, client, config_file, monkeypatch):
+        """Test that no limit applies when MAX_GOROUTINES is unset"""
+        monkeypatch.delenv("MAX_GOROUTINES", raising=False)
+
+        assert client.get("/config").status_code == 200
+
+
+class TestEcho:
+    """Test the /echo webhook target"""
+
+    def test_round_trip(self, client):
+        """Test that the body and Content-Type come back unchanged"""
+        payload = '{"status": "firing", "alerts": [{"labels": {"alertname": "AlertExampleDown"}}]}'
+
+        response = client.post("/echo", content=payload, headers={"Content-Type": "application/json"})
+
+        assert response.status_code == 200
+        assert response.text == payload
+        assert response.headers["content-type"] == "application/json"
+
+    def test_history_keeps_last_n(self, client, monkeypatch):
+        """Test that /echo/history retains only the most recent ECHO_HISTORY_SIZE bodies, oldest first"""
+        monkeypatch.setenv("ECHO_HISTORY_SIZE", "3")
+        for i in range(5):
+            client.post("/echo", content=f"body {i}", headers={"Content-Type": "text/plain"})
+
+        history = client.get("/echo/history").json()
+
+        assert [entry["body"] for entry in history] == ["body 2", "body 3", "body 4"]
+        assert all(entry["content_type"] == "text/plain" for entry in history)
+
+    def test_oversized_body_rejected(self, client, monkeypatch):
+        """Test that bodies over MAX_CONFIG_BYTES are a 413 and not recorded"""
+        monkeypatch.setenv("MAX_CONFIG_BYTES", "8")
+
+        assert client.post("/echo", content="x" * 9).status_code == 413
+        assert client.get("/echo/history").json() == []
+
+
+class TestAlertForward:
+    """Test POST /alert relaying to FORWARD_URL"""
+
+    PAYLOAD = b'{"alerts": [{"labels": {"alertname": "ConfigCrash"}}]}'
+
+    @pytest.fixture
+    def downstream(self, upstream, monkeypatch):
+        monkeypatch.setenv("FORWARD_URL", upstream.url + "/hook")
+        monkeypatch.setenv("FORWARD_BACKOFF_MS", "100")
+        return upstream
+
+    def _post(self, client):
+        return client.post("/alert", content=self.PAYLOAD, headers={"Content-Type": "application/json"})
+
+    def test_retries_until_success(self, client, downstream, capsys):
+        """Test that failures are retried with exponential backoff and the final status is returned"""
+        downstream.statuses = [503, 502]
+        downstream.status = 202
+        downstream.body = b"accepted"
+
+        started = time.monotonic()
+        response = self._post(client)
+
+        assert response.status_code == 202
+        assert response.text == "accepted"
+        # 100ms then 200ms between the three attempts
+        assert time.monotonic() - started >= 0.3
+        assert len(downstream.requests) == 3
+        for method, path, headers, body in downstream.requests:
+            assert (method, path, body) == ("POST", "/hook", self.PAYLOAD)
+            assert headers["Content-Type"] == "application/json"
+        logged = capsys.readouterr()
+        assert "attempt=3" in logged.out
+        assert "attempt=1" in logged.err
+
+    def test_last_status_returned_when_retries_exhausted(self, client, downstream, monkeypatch):
+        """Test that FORWARD_RETRIES bounds the attempts and the last 5xx is passed through"""
+        monkeypatch.setenv("FORWARD_RETRIES", "2")
+        monkeypatch.setenv("FORWARD_BACKOFF_MS", "1")
+        downstream.status = 500
+
+        assert self._post(client).status_code == 500
+        assert len(downstream.requests) == 3
+
+    def test_client_errors_not_retried(self, client, downstream):
+        """Test that a 4xx from downstream is returned after one attempt"""
+        downstream.status = 400
+
+        assert self._post(client).status_code == 400
+        assert len(downstream.requests) == 1
+
+    def test_total_timeout(self, client, downstream, monkeypatch):
+        """Test that retries stop once the next backoff would pass FORWARD_TIMEOUT_SECONDS"""
+        monkeypatch.setenv("FORWARD_TIMEOUT_SECONDS", "0.2")
+        monkeypatch.setenv("FORWARD_BACKOFF_MS", "500")
+        downstream.status = 503
+
+        assert self._post(client).status_code == 504
+        assert len(downstream.requests) == 1
+
+    def test_unreachable_downstream(self, client, monkeypatch):
+        """Test that connection failures on every attempt are a 502"""
+        monkeypatch.setenv("FORWARD_URL", f"http://127.0.0.1:{_free_port()}/hook")
+        monkeypatch.setenv("FORWARD_RETRIES", "1")
+        monkeypatch.setenv("FORWARD_BACKOFF_MS", "1")
+
+        assert self._post(client).status_code == 502
+
+    def test_disabled_without_forward_url(self, client, monkeypatch):
+        """Test that /alert is a 404 unless FORWARD_URL is set"""
+        monkeypatch.delenv("FORWARD_URL", raising=False)
+
+        assert self._post(client).status_code == 404
+
+
+class TestShouldCrash:
+    """Test crash keyword matching"""
+
+    def test_default_keyword(self, monkeypatch):
+        """Test that the Crash keyword arms the crash when CRASH_KEYWORD is unset"""
+        monkeypatch.delenv("CRASH_KEYWORD", raising=False)
+        assert alert_app.should_crash('message: "Crash on startup"')
+        assert not alert_app.should_crash('message: "all good"')
+
+    def test_empty_keyword_disables_crash(self, monkeypatch):
+        """Test that an empty CRASH_KEYWORD never arms the crash"""
+        monkeypatch.setenv("CRASH_KEYWORD", "")
+        assert not alert_app.should_crash('message: "Crash on startup"')
+
+    def test_single_keyword(self, monkeypatch):
+        """Test that a custom keyword replaces the default"""
+        monkeypatch.setenv("CRASH_KEYWORD", "Boom")
+        assert alert_app.should_crash('message: "Boom"')
+        assert not alert_app.should_crash('message: "Crash"')
+
+    def test_multiple_keywords_one_matching(self, monkeypatch):
+        """Test that any keyword from a comma-separated list arms the crash"""
+        monkeypatch.setenv("CRASH_KEYWORD", "Boom, Kaput")
+        assert alert_app.should_crash('message: "went Kaput"')
+        assert not alert_app.should_crash('message: "fine"')
+
+
+class TestLogEvent:
+    """Test the log_event helper"""
+
+    def test_text_format_is_default(self, monkeypatch, capsys):
+        """Test that the text format keeps the LEVEL: prefix and appends fields"""
+        monkeypatch.delenv("LOG_FORMAT", raising=False)
+        alert_app.log_event("ERROR", "failed to read config", path="/config", status=500)
+
+        assert capsys.readouterr().err == "ERROR: failed to read config path=/config status=500\n"
+
+    def test_json_format(self, monkeypatch, capsys):
+        """Test that LOG_FORMAT=json emits one JSON object per line"""
+        monkeypatch.setenv("LOG_FORMAT", "json")
+        alert_app.log_event("WARN", "slow read", path="/config", status=200)
+
+        event = json.loads(capsys.readouterr().err)
+        assert event["level"] == "WARN"
+        assert event["msg"] == "slow read"
+        assert event["path"] == "/config"
+        assert event["status"] == 200
+        assert event["ts"].endswith("Z")
+
+    def test_json_format_covers_server_logs(self, monkeypatch, capfd):
+        """Test that uvicorn's lifecycle and access lines are JSON objects too under LOG_FORMAT=json"""
+        monkeypatch.setenv("LOG_FORMAT", "json")
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        try:
+            assert httpx.get(f"http://127.0.0.1:{port}/healthz", timeout=5).status_code == 200
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+        captured = capfd.readouterr()
+        events = [json.loads(line) for line in (captured.out + captured.err).splitlines() if line.strip()]
+        assert any(e.get("logger") == "uvicorn.error" and "Uvicorn running" in e["msg"] for e in events)
+        assert any(e.get("logger") == "uvicorn.access" and "/healthz" in e["msg"] for e in events)
+        assert all(e["ts"].endswith("Z") and e["level"] in ("INFO", "WARN", "ERROR") for e in events)
+
+    def test_info_goes_to_stdout(self, monkeypatch, capsys):
+        """Test that INFO lines go to stdout and warnings/errors to stderr"""
+        monkeypatch.delenv("LOG_FORMAT", raising=False)
+        alert_app.log_event("INFO", "started")
+
+        captured = capsys.readouterr()
+        assert captured.out == "INFO: started\n"
+        assert captured.err == ""
+
+
+class TestStartupSettings:
+    """Test the structured startup event"""
+
+    def test_event_has_settings_and_redacts_password(self, config_file, monkeypatch, capsys):
+        """Test that main logs one JSON event with the effective settings and no secrets"""
+        monkeypatch.setenv("LOG_FORMAT", "json")
+        monkeypatch.setenv("PORT", "9000")
+        monkeypatch.setenv("CRASH_KEYWORD", "Crash,Boom")
+        monkeypatch.setenv("ERROR_RATE", "0.25")
+        monkeypatch.setenv("WATCH_CONFIG", "false")
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+        async def no_serve(*servers):
+            pass
+
+        monkeypatch.setattr(alert_app, "build_server", lambda *args, **kwargs: None)
+        monkeypatch.setattr(alert_app, "_serve", no_serve)
+
+        alert_app.main([])
+
+        out = capsys.readouterr().out
+        events = [json.loads(line) for line in out.splitlines()]
+        startup = [e for e in events if e["msg"] == "startup settings"]
+        assert len(startup) == 1
+        event = startup[0]
+        assert event["config_path"] == str(config_file)
+        assert event["listen_addr"] == "0.0.0.0:9000"
+        assert event["crash_keyword"] == "Crash,Boom"
+        assert event["error_rate"] == 0.25
+        for toggle in ("watch_config", "validate_yaml", "recover_panics", "access_log", "tls"):
+            assert toggle in event
+        assert event["basic_auth_user"] == "admin"
+        assert event["basic_auth_pass"] == "***"
+        assert "s3cret" not in out
+
+
+class TestEnvEndpoint:
+    """Test the /env effective-settings endpoint"""
+
+    def test_reports_settings_with_types(self, client, config_file, monkeypatch):
+        """Test that known toggles are present with their effective values and JSON types"""
+        monkeypatch.setenv("EXPOSE_ENV", "true")
+        monkeypatch.setenv("WATCH_CONFIG", "true")
+        monkeypatch.setenv("ERROR_RATE", "0.5")
+        monkeypatch.setenv("CRASH_EXIT_CODE", "9")
+
+        response = client.get("/env")
+
+        assert response.status_code == 200
+        settings = response.json()
+        assert settings["config_path"] == str(config_file)
+        assert settings["watch_config"] is True
+        assert settings["validate_yaml"] is False
+        assert settings["error_rate"] == 0.5
+        assert settings["crash_exit_code"] == 9
+        assert isinstance(settings["shutdown_grace_seconds"], int)
+
+    def test_secrets_redacted(self, client, monkeypatch):
+        """Test that the basic-auth password and TLS key path are not exposed"""
+        monkeypatch.setenv("EXPOSE_ENV", "true")
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+        monkeypatch.setenv("TLS_CERT_FILE", "/tls/tls.crt")
+        monkeypatch.setenv("TLS_KEY_FILE", "/tls/private.key")
+
+        response = client.get("/env")
+
+        settings = response.json()
+        assert settings["basic_auth_pass"] == "***"
+        assert settings["tls_key_file"] == "***"
+        assert settings["tls_cert_file"] == "/tls/tls.crt"
+        assert "s3cret" not in response.text
+        assert "private.key" not in response.text
+
+    def test_disabled_by_default(self, client, monkeypatch):
+        """Test that /env is a 404 unless EXPOSE_ENV is set"""
+        monkeypatch.delenv("EXPOSE_ENV", raising=False)
+
+        assert client.get("/env").status_code == 404
+
+
+class TestCrashExitCode:
+    """Test CRASH_EXIT_CODE resolution"""
+
+    def test_unset_defaults_to_one(self, monkeypatch):
+        """Test the default exit code"""
+        monkeypatch.delenv("CRASH_EXIT_CODE", raising=False)
+        assert alert_app.get_crash_exit_code() == 1
+
+    def test_valid_values(self, monkeypatch):
+        """Test that codes in 1-255 are used as given"""
+        for raw, expected in [("1", 1), ("42", 42), ("255", 255)]:
+            monkeypatch.setenv("CRASH_EXIT_CODE", raw)
+            assert alert_app.get_crash_exit_code() == expected
+
+    def test_invalid_values_fall_back_to_one(self, monkeypatch):
+        """Test that out-of-range and non-numeric codes fall back to 1"""
+        for raw in ("0", "256", "-3", "boom"):
+            monkeypatch.setenv("CRASH_EXIT_CODE", raw)
+            assert alert_app.get_crash_exit_code() == 1
+
+
+class TestCrashMode:
+    """Test CRASH_MODE=exit versus CRASH_MODE=degrade"""
+
+    @pytest.fixture
+    def exits(self, config_file, monkeypatch):
+        config_file.write_text('message: "Crash"\n')
+        monkeypatch.setenv("CRASH_DELAY_MS", "0")
+        recorded = []
+        monkeypatch.setattr(alert_app, "exit_func", recorded.append)
+        return recorded
+
+    def test_exit_mode_is_default(self, client, exits, monkeypatch):
+        """Test that without CRASH_MODE the keyword calls the exit function and health stays ok"""
+        monkeypatch.delenv("CRASH_MODE", raising=False)
+
+        assert client.get("/config").status_code == 500
+
+        assert exits == [1]
+        assert client.get("/healthz").status_code == 200
+
+    def test_degrade_mode_fails_healthz(self, client, exits, monkeypatch, capsys):
+        """Test that degrade mode flips /healthz to 503 with the reason instead of exiting"""
+        monkeypatch.setenv("CRASH_MODE", "degrade")
+
+        response = client.get("/config")
+
+        assert response.status_code == 500
+        assert exits == []
+        health = client.get("/healthz")
+        assert health.status_code == 503
+        assert health.text == "unhealthy: config contained crash keyword"
+        assert "degrading health instead of exiting" in capsys.readouterr().err
+
+    def test_degrade_mode_on_startup(self, exits, monkeypatch):
+        """Test that the startup check degrades rather than exits"""
+        monkeypatch.setenv("CRASH_MODE", "degrade")
+
+        alert_app.startup_check()
+
+        assert exits == []
+        assert not alert_app.health_flip.healthy()
+
+    def test_degrade_mode_on_reload(self, client, exits, monkeypatch):
+        """Test that a crash-armed reload degrades rather than scheduling an exit"""
+        monkeypatch.setenv("CRASH_MODE", "degrade")
+
+        assert client.post("/config/reload").json() == {"success": True, "crash_armed": True}
+
+        assert exits == []
+        assert client.get("/healthz").status_code == 503
+
+    def test_invalid_mode_falls_back_to_exit(self, monkeypatch):
+        """Test that an unknown CRASH_MODE behaves like exit"""
+        monkeypatch.setenv("CRASH_MODE", "explode")
+        assert alert_app.get_crash_mode() == "exit"
+
+
+class TestCrashDelay:
+    """Test CRASH_DELAY_MS on the request-triggered crash path"""
+
+    @pytest.fixture
+    def exits(self, config_file, monkeypatch):
+        config_file.write_text('message: "Crash"\n')
+        recorded = []
+        exited = threading.Event()
+
+        def fake_exit(code):
+            recorded.append((code, time.monotonic()))
+            exited.set()
+
+        monkeypatch.setattr(alert_app, "exit_func", fake_exit)
+        return recorded, exited
+
+    def test_default_delay(self, client, exits, monkeypatch):
+        """Test that the process exits about 500ms after the crash response"""
+        monkeypatch.delenv("CRASH_DELAY_MS", raising=False)
+        recorded, exited = exits
+
+        response = client.get("/config")
+        responded_at = time.monotonic()
+
+        assert response.status_code == 500
+        assert exited.wait(timeout=5)
+        code, exited_at = recorded[0]
+        assert code == 1
+        assert exited_at - responded_at >= 0.4
+
+    def test_configured_delay(self, client, exits, monkeypatch):
+        """Test that the exit waits for the configured delay"""
+        monkeypatch.setenv("CRASH_DELAY_MS", "150")
+        recorded, exited = exits
+
+        response = client.get("/config")
+        responded_at = time.monotonic()
+
+        assert response.status_code == 500
+        assert not recorded
+        assert exited.wait(timeout=5)
+        assert 0.1 <= recorded[0][1] - responded_at < 0.5
+
+    def test_zero_exits_once_response_is_written(self, client, exits, monkeypatch):
+        """Test that CRASH_DELAY_MS=0 exits synchronously after the response body"""
+        monkeypatch.setenv("CRASH_DELAY_MS", "0")
+        recorded, _ = exits
+
+        response = client.get("/config")
+
+        # TestClient runs background tasks before returning, so the exit has already happened
+        assert response.status_code == 500
+        assert response.text == "config triggered crash"
+        assert [code for code, _ in recorded] == [1]
+
+
+class TestExitFunc:
+    """Test that termination is routed through exit_func"""
+
+    @pytest.fixture
+    def exits(self, monkeypatch):
+        recorded = []
+        monkeypatch.setattr(alert_app, "exit_func", recorded.append)
+        return recorded
+
+    def test_startup_crash(self, config_file, exits, monkeypatch):
+        """Test that a crash keyword at startup exits with CRASH_EXIT_CODE"""
+        monkeypatch.setenv("CRASH_EXIT_CODE", "3")
+        config_file.write_text('message: "Crash"\n')
+
+        alert_app.startup_check()
+
+        assert exits == [3]
+
+    def test_startup_read_failure(self, tmp_path, exits, monkeypatch):
+        """Test that an unreadable config at startup exits with 1"""
+        monkeypatch.setenv("CONFIG_PATH", str(tmp_path / "missing.yaml"))
+
+        alert_app.startup_check()
+
+        assert exits == [1]
+
+    def test_startup_clean(self, config_file, exits):
+        """Test that a clean config at startup does not exit"""
+        alert_app.startup_check()
+
+        assert exits == []
+
+    def test_request_crash(self, client, config_file, exits, monkeypatch):
+        """Test that a crash-armed /config request exits with CRASH_EXIT_CODE"""
+        monkeypatch.setenv("CRASH_DELAY_MS", "0")
+        monkeypatch.setenv("CRASH_EXIT_CODE", "7")
+        config_file.write_text('message: "Crash"\n')
+
+        response = client.get("/config")
+
+        assert response.status_code == 500
+        assert exits == [7]
+
+    def test_request_clean(self, client, config_file, exits):
+        """Test that serving a clean config does not exit"""
+        response = client.get("/config")
+
+        assert response.status_code == 200
+        assert exits == []
+
+
+class TestRequireConfig:
+    """Test REQUIRE_CONFIG for a config that arrives after startup"""
+
+    @pytest.fixture
+    def missing_config(self, tmp_path, monkeypatch):
+        path = tmp_path / "config.yaml"
+        monkeypatch.setenv("CONFIG_PATH", str(path))
+        return path
+
+    @pytest.fixture
+    def exits(self, monkeypatch):
+        recorded = []
+        monkeypatch.setattr(alert_app, "exit_func", recorded.append)
+        return recorded
+
+    def test_required_by_default(self, missing_config, exits, monkeypatch):
+        """Test that a missing config still exits at startup when REQUIRE_CONFIG is unset"""
+        monkeypatch.delenv("REQUIRE_CONFIG", raising=False)
+
+        alert_app.startup_check()
+
+        assert exits == [1]
+
+    def test_optional_config_starts_not_ready(self, client, missing_config, exits, monkeypatch):
+        """Test that REQUIRE_CONFIG=false starts not-ready and /config is a 503 until the file appears"""
+        monkeypatch.setenv("REQUIRE_CONFIG", "false")
+
+        alert_app.startup_check()
+
+        assert exits == []
+        assert client.get("/readyz").status_code == 503
+        response = client.get("/config")
+        assert response.status_code == 503
+        assert response.text == "config not available yet"
+
+        missing_config.write_text('message: "arrived"\n')
+
+        assert client.get("/config").text == 'message: "arrived"\n'
+        assert client.get("/readyz").status_code == 200
+
+    def test_missing_config_is_500_when_required(self, client, missing_config, monkeypatch):
+        """Test that without REQUIRE_CONFIG=false a missing file keeps returning 500"""
+        monkeypatch.delenv("REQUIRE_CONFIG", raising=False)
+
+        assert client.get("/config").status_code == 500
+
+    def test_fail_startup_read_exits(self, config_file, exits, monkeypatch, capsys):
+        """Test that FAIL_STARTUP_READ fails an existing config's startup read and exits 1"""
+        monkeypatch.setenv("FAIL_STARTUP_READ", "true")
+        monkeypatch.delenv("REQUIRE_CONFIG", raising=False)
+
+        alert_app.startup_check()
+
+        assert exits == [1]
+        assert "could not read config on startup: simulated failure (FAIL_STARTUP_READ)" in capsys.readouterr().err
+        assert config_file.read_text() == 'message: "all good"\n'
+
+    def test_fail_startup_read_recovers_when_optional(self, client, config_file, exits, monkeypatch):
+        """Test that with REQUIRE_CONFIG=false only the startup read fails and the next read recovers"""
+        monkeypatch.setenv("FAIL_STARTUP_READ", "true")
+        monkeypatch.setenv("REQUIRE_CONFIG", "false")
+
+        alert_app.startup_check()
+
+        assert exits == []
+        assert client.get("/readyz").status_code == 503
+        assert client.get("/config").text == 'message: "all good"\n'
+        assert client.get("/readyz").status_code == 200
+
+
+class TestCrashTimer:
+    """Test the CRASH_AFTER_SECONDS uptime crash"""
+
+    def test_fires_once_after_interval(self, monkeypatch, capsys):
+        """Test that the timer calls exit_func once with the crash exit code"""
+        monkeypatch.setenv("CRASH_AFTER_SECONDS", "0.2")
+        monkeypatch.setenv("CRASH_EXIT_CODE", "4")
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+
+        started = time.monotonic()
+        timer = alert_app.start_crash_timer()
+        timer.join(timeout=5)
+
+        assert exits == [4]
+        assert time.monotonic() - started >= 0.2
+        time.sleep(0.3)
+        assert exits == [4]
+        assert "crash timer expired" in capsys.readouterr().err
+
+    def test_disabled_by_default(self, monkeypatch):
+        """Test that no timer is armed without CRASH_AFTER_SECONDS"""
+        monkeypatch.delenv("CRASH_AFTER_SECONDS", raising=False)
+
+        assert alert_app.start_crash_timer() is None
+
+    def test_jitter_within_window_for_seed(self, monkeypatch):
+        """Test that a seeded jittered delay is reproducible and stays within ±CRASH_JITTER_SECONDS"""
+        monkeypatch.setenv("CRASH_AFTER_SECONDS", "60")
+        monkeypatch.setenv("CRASH_JITTER_SECONDS", "10")
+        monkeypatch.setenv("CRASH_SEED", "42")
+
+        delays = [alert_app.crash_after_seconds() for _ in range(3)]
+
+        assert 50 <= delays[0] <= 70
+        assert delays[0] != 60
+        assert delays == [delays[0]] * 3
+        spread = [alert_app.crash_after_seconds(random.Random(seed)) for seed in range(200)]
+        assert all(50 <= delay <= 70 for delay in spread)
+        assert min(spread) < 55 and max(spread) > 65
+
+    def test_jitter_never_negative(self, monkeypatch):
+        """Test that jitter larger than the base delay is clamped at zero"""
+        monkeypatch.setenv("CRASH_AFTER_SECONDS", "1")
+        monkeypatch.setenv("CRASH_JITTER_SECONDS", "100")
+
+        assert all(alert_app.crash_after_seconds(random.Random(seed)) >= 0 for seed in range(50))
+
+    def test_no_jitter_by_default(self, monkeypatch):
+        """Test that without CRASH_JITTER_SECONDS the delay is exact"""
+        monkeypatch.setenv("CRASH_AFTER_SECONDS", "30")
+        monkeypatch.delenv("CRASH_JITTER_SECONDS", raising=False)
+
+        assert alert_app.crash_after_seconds() == 30
+
+    def test_logs_computed_crash_time(self, monkeypatch, capsys):
+        """Test that arming the timer logs the jittered delay and the absolute crash time"""
+        monkeypatch.setenv("CRASH_AFTER_SECONDS", "60")
+        monkeypatch.setenv("CRASH_JITTER_SECONDS", "10")
+        monkeypatch.setenv("CRASH_SEED", "42")
+        monkeypatch.setattr(alert_app, "exit_func", lambda code: None)
+        expected = alert_app.crash_after_seconds()
+
+        timer = alert_app.start_crash_timer()
+        timer.cancel()
+
+        out = capsys.readouterr().out
+        assert f"crash timer armed after_seconds={round(expected, 3)} crash_at=" in out
+
+
+class TestAdminPort:
+    """Test the ADMIN_PORT listener split"""
+
+    def test_admin_routes_only_on_admin_port(self, config_file):
+        """Test that probes and metrics move to the admin port while /config stays on the main one"""
+        port, admin_port = _free_port(), _free_port()
+        servers = alert_app.build_servers("127.0.0.1", port, None, admin_port)
+        threads = [_start_server(server) for server in servers]
+        main_url, admin_url = f"http://127.0.0.1:{port}", f"http://127.0.0.1:{admin_port}"
+        try:
+            for path in ("/healthz", "/readyz", "/metrics"):
+                assert httpx.get(admin_url + path).status_code in (200, 503), path
+                assert httpx.get(main_url + path).status_code == 404, path
+            assert httpx.post(admin_url + "/admin/drain").status_code == 200
+            assert httpx.post(main_url + "/admin/drain").status_code == 404
+
+            assert httpx.get(main_url + "/config").status_code == 200
+            assert httpx.get(admin_url + "/config").status_code == 404
+        finally:
+            alert_app._request_shutdown_all(servers, signal.SIGTERM)
+            for thread in threads:
+                thread.join(timeout=10)
+
+        # Both servers drain on the same signal
+        assert not any(thread.is_alive() for thread in threads)
+
+    def test_single_server_without_admin_port(self):
+        """Test that all routes stay on one server by default"""
+        servers = alert_app.build_servers("127.0.0.1", _free_port(), None)
+
+        assert len(servers) == 1
+        assert servers[0].config.app.app is alert_app.app
+
+    def test_invalid_admin_port_rejected(self, monkeypatch):
+        """Test that a non-numeric ADMIN_PORT is a clear error"""
+        monkeypatch.setenv("ADMIN_PORT", "admin")
+
+        with pytest.raises(ValueError, match="invalid ADMIN_PORT"):
+            alert_app.get_admin_port()
+
+
+class TestTCPHealth:
+    """Test the TCP_HEALTH_PORT raw socket liveness listener"""
+
+    def test_accepts_and_closes_connections(self):
+        """Test that a dial succeeds and the app closes the connection straight away"""
+        port = _free_port()
+        server = alert_app._TCPHealthServer("127.0.0.1", port)
+        thread = _start_server(server)
+        try:
+            with socket.create_connection(("127.0.0.1", port), timeout=2) as sock:
+                assert sock.recv(1) == b""
+        finally:
+            alert_app.request_shutdown(server, signal.SIGTERM)
+            thread.join(timeout=5)
+
+        assert not thread.is_alive()
+        with pytest.raises(OSError):
+            socket.create_connection(("127.0.0.1", port), timeout=0.5)
+
+    def test_runs_alongside_http_server(self):
+        """Test that build_servers adds the listener and SIGTERM stops it with the HTTP server"""
+        port, tcp_port = _free_port(), _free_port()
+        servers = alert_app.build_servers("127.0.0.1", port, None, tcp_health_port=tcp_port)
+        threads = [_start_server(server) for server in servers]
+        try:
+            assert len(servers) == 2
+            socket.create_connection(("127.0.0.1", tcp_port), timeout=2).close()
+            assert httpx.get(f"http://127.0.0.1:{port}/healthz").status_code == 200
+        finally:
+            alert_app._request_shutdown_all(servers, signal.SIGTERM)
+            for thread in threads:
+                thread.join(timeout=10)
+
+        assert not any(thread.is_alive() for thread in threads)
+
+    def test_invalid_port_rejected(self, monkeypatch):
+        """Test that a bad TCP_HEALTH_PORT is a clear error and unset means no listener"""
+        monkeypatch.setenv("TCP_HEALTH_PORT", "70000")
+        with pytest.raises(ValueError, match="invalid TCP_HEALTH_PORT"):
+            alert_app.get_tcp_health_port()
+
+        monkeypatch.delenv("TCP_HEALTH_PORT")
+        assert alert_app.get_tcp_health_port() is None
+
+
+class TestServerTimeouts:
+    """Test server timeout settings"""
+
+    def test_server_built_from_settings(self):
+        """Test that each timeout in the settings lands on the built server"""
+        timeouts = alert_app.ServerTimeouts(read_header=1, read=2, write=3, idle=4)
+
+        server = alert_app.build_server("127.0.0.1", _free_port(), timeouts=timeouts)
+
+        assert server.config.timeout_keep_alive == 4
+        wrapper = server.config.app
+        assert isinstance(wrapper, alert_app._TimeoutMiddleware)
+        assert wrapper.read_timeout == 2
+        assert wrapper.write_timeout == 3
+        assert wrapper.app is alert_app.app
+
+    def test_defaults_and_env(self, monkeypatch):
+        """Test the 5/30/30/60 defaults and the env overrides"""
+        for name in ("READ_HEADER_TIMEOUT_SECONDS", "READ_TIMEOUT_SECONDS", "WRITE_TIMEOUT_SECONDS"):
+            monkeypatch.delenv(name, raising=False)
+        monkeypatch.setenv("IDLE_TIMEOUT_SECONDS", "15")
+
+        assert alert_app.get_server_timeouts() == alert_app.ServerTimeouts(read_header=5, read=30, write=30, idle=15)
+
+    def test_stalled_body_read_times_out(self):
+        """Test that a body read exceeding the read timeout is reported as a disconnect"""
+        seen = []
+
+        async def downstream(scope, receive, send):
+            seen.append(await receive())
+
+        async def stalled_receive():
+            await asyncio.sleep(5)
+
+        wrapper = alert_app._TimeoutMiddleware(downstream, read_timeout=0.1, write_timeout=1)
+        asyncio.run(wrapper({"type": "http", "path": "/echo"}, stalled_receive, None))
+
+        assert seen == [{"type": "http.disconnect"}]
+
+
+class TestGracefulShutdown:
+    """Test signal-driven graceful shutdown"""
+
+    def test_in_flight_request_completes_before_shutdown_returns(self, config_file, monkeypatch):
+        """Test that a slow /config request is drained rather than dropped on SIGTERM"""
+        monkeypatch.setenv("SHUTDOWN_GRACE_SECONDS", "5")
+        read_config = alert_app.read_config
+
+        def slow_read_config():
+            time.sleep(1.0)
+            return read_config()
+
+        monkeypatch.setattr(alert_app, "read_config", slow_read_config)
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        server_thread = _start_server(server)
+
+        result = {}
+
+        def fire_request():
+            result["response"] = httpx.get(f"http://127.0.0.1:{port}/config", timeout=10)
+            result["finished_at"] = time.monotonic()
+
+        request_thread = threading.Thread(target=fire_request)
+        request_thread.start()
+        time.sleep(0.3)
+
+        alert_app.request_shutdown(server, signal.SIGTERM)
+        server_thread.join(timeout=10)
+        shutdown_returned_at = time.monotonic()
+        request_thread.join(timeout=10)
+
+        assert not server_thread.is_alive()
+        assert result["response"].status_code == 200
+        assert result["finished_at"] <= shutdown_returned_at
+
+    def test_prestop_delay_fails_readiness_but_keeps_serving(self, config_file, monkeypatch):
+        """Test that readiness fails at once on SIGTERM while /config works until the delay ends"""
+        monkeypatch.setenv("PRESTOP_DELAY_SECONDS", "1")
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        thread = _start_server(server)
+        url = f"http://127.0.0.1:{port}"
+        try:
+            assert httpx.get(url + "/config").status_code == 200
+            assert httpx.get(url + "/readyz").status_code == 200
+
+            signalled_at = time.monotonic()
+            alert_app.begin_shutdown((server,), signal.SIGTERM)
+
+            assert httpx.get(url + "/readyz").status_code == 503
+            assert httpx.get(url + "/config").status_code == 200
+            assert not server.should_exit
+            thread.join(timeout=10)
+            assert time.monotonic() - signalled_at >= 1.0
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+        assert not thread.is_alive()
+
+    def test_no_prestop_delay_drains_immediately(self, monkeypatch):
+        """Test that without PRESTOP_DELAY_SECONDS the signal starts the drain right away"""
+        monkeypatch.delenv("PRESTOP_DELAY_SECONDS", raising=False)
+        server = alert_app.build_server(host="127.0.0.1", port=_free_port())
+
+        alert_app.begin_shutdown((server,), signal.SIGTERM)
+
+        assert server.should_exit
+        assert alert_app.readiness.check() == (False, "draining")
+
+    def test_grace_period_defaults_and_validation(self, monkeypatch):
+        """Test the grace period default and fallback on invalid values"""
+        monkeypatch.delenv("SHUTDOWN_GRACE_SECONDS", raising=False)
+        assert alert_app.get_shutdown_grace_seconds() == 10
+
+        monkeypatch.setenv("SHUTDOWN_GRACE_SECONDS", "3")
+        assert alert_app.get_shutdown_grace_seconds() == 3
+
+        monkeypatch.setenv("SHUTDOWN_GRACE_SECONDS", "soon")
+        assert alert_app.get_shutdown_grace_seconds() == 10
+
+    def test_hang_delays_exit(self, monkeypatch):
+        """Test that SHUTDOWN_HANG_SECONDS keeps _serve from returning that long after the drain"""
+        monkeypatch.setenv("SHUTDOWN_HANG_SECONDS", "0.5")
+        monkeypatch.setenv("SHUTDOWN_GRACE_SECONDS", "0")
+        server = alert_app.build_server(host="127.0.0.1", port=_free_port())
+        threading.Timer(0.2, alert_app.begin_shutdown, args=((server,), signal.SIGTERM)).start()
+        started = time.monotonic()
+
+        asyncio.run(alert_app._serve(server))
+
+        assert time.monotonic() - started >= 0.7
+
+    def test_second_signal_cuts_hang_short(self, monkeypatch):
+        """Test that another signal during the hang forces an immediate exit"""
+        monkeypatch.setenv("SHUTDOWN_HANG_SECONDS", "30")
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+        server = alert_app.build_server(host="127.0.0.1", port=_free_port())
+        threading.Timer(0.2, alert_app.begin_shutdown, args=((server,), signal.SIGTERM)).start()
+        threading.Timer(0.6, alert_app.begin_shutdown, args=((server,), signal.SIGTERM)).start()
+        started = time.monotonic()
+
+        asyncio.run(alert_app._serve(server))
+
+        assert 0.6 <= time.monotonic() - started < 5
+        assert exits == [1]
+
+
+class TestRemoteShutdown:
+    """Test POST /admin/shutdown"""
+
+    @staticmethod
+    def _can_connect(port):
+        try:
+            socket.create_connection(("127.0.0.1", port), timeout=0.2).close()
+            return True
+        except OSError:
+            return False
+
+    def test_shutdown_stops_accepting_connections(self, monkeypatch):
+        """Test that the caller gets a 202 and the server then stops and refuses new connections"""
+        monkeypatch.setenv("ALLOW_REMOTE_SHUTDOWN", "true")
+        monkeypatch.setenv("SHUTDOWN_GRACE_SECONDS", "2")
+        port = _free_port()
+        server = alert_app.build_server(host="127.0.0.1", port=port)
+        monkeypatch.setattr(alert_app, "running_servers", (server,))
+        thread = _start_server(server)
+        try:
+            response = httpx.post(f"http://127.0.0.1:{port}/admin/shutdown", timeout=5)
+
+            assert response.status_code == 202
+            assert response.json() == {"shutting_down": True, "grace_seconds": 2}
+            thread.join(timeout=10)
+            assert not thread.is_alive()
+            assert not self._can_connect(port)
+        finally:
+            server.should_exit = True
+            thread.join(timeout=5)
+
+    def test_disabled_by_default(self, client, monkeypatch):
+        """Test that without ALLOW_REMOTE_SHUTDOWN or basic auth the endpoint is a 404"""
+        monkeypatch.delenv("ALLOW_REMOTE_SHUTDOWN", raising=False)
+        monkeypatch.delenv("BASIC_AUTH_USER", raising=False)
+        server = alert_app.build_server(host="127.0.0.1", port=_free_port())
+        monkeypatch.setattr(alert_app, "running_servers", (server,))
+
+        assert client.post("/admin/shutdown").status_code == 404
+        assert server.should_exit is False
+
+    def test_basic_auth_required_when_configured(self, client, monkeypatch):
+        """Test that configured basic auth both enables the endpoint and guards it"""
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+        server = alert_app.build_server(host="127.0.0.1", port=_free_port())
+        monkeypatch.setattr(alert_app, "running_servers", (server,))
+
+        assert client.post("/admin/shutdown").status_code == 401
+        assert server.should_exit is False
+
+        response = client.post("/admin/shutdown", auth=("admin", "s3cret"))
+
+        assert response.status_code == 202
+        assert server.should_exit is True
+
+
+class TestRemoteCrash:
+    """Test POST /admin/crash"""
+
+    @pytest.fixture
+    def exits(self, monkeypatch):
+        exits = []
+        monkeypatch.setattr(alert_app, "exit_func", exits.append)
+        monkeypatch.setenv("CRASH_DELAY_MS", "0")
+        return exits
+
+    def test_crash_exits_with_configured_code(self, client, exits, monkeypatch, capsys):
+        """Test that the caller gets a 202 and the exit function then records CRASH_EXIT_CODE"""
+        monkeypatch.setenv("ALLOW_REMOTE_CRASH", "true")
+        monkeypatch.setenv("CRASH_EXIT_CODE", "42")
+
+        response = client.post("/admin/crash", headers={"User-Agent": "chaos-runner"})
+
+        assert response.status_code == 202
+        assert response.json() == {"crashing": True, "exit_code": 42, "delay_ms": 0}
+        assert exits == [42]
+        err = capsys.readouterr().err
+        assert "crash requested" in err
+        assert "user_agent=chaos-runner" in err
+
+    def test_waits_for_crash_delay(self, client, exits, monkeypatch):
+        """Test that the exit happens only after CRASH_DELAY_MS"""
+        monkeypatch.setenv("ALLOW_REMOTE_CRASH", "true")
+        monkeypatch.setenv("CRASH_DELAY_MS", "200")
+
+        assert client.post("/admin/crash").status_code == 202
+        assert exits == []
+        assert _wait_for(lambda: exits == [1])
+
+    def test_disabled_by_default(self, client, exits, monkeypatch):
+        """Test that without ALLOW_REMOTE_CRASH the endpoint is a 404 and nothing exits"""
+        monkeypatch.delenv("ALLOW_REMOTE_CRASH", raising=False)
+
+        assert client.post("/admin/crash").status_code == 404
+        assert exits == []
+
+    def test_basic_auth_required_when_configured(self, client, exits, monkeypatch):
+        """Test that configured basic auth guards the endpoint"""
+        monkeypatch.setenv("ALLOW_REMOTE_CRASH", "true")
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+        assert client.post("/admin/crash").status_code == 401
+        assert exits == []
+
+        assert client.post("/admin/crash", auth=("admin", "s3cret")).status_code == 202
+        assert exits == [1]
+
+
+class TestRuntimeConfig:
+    """Test POST /admin/config"""
+
+    AUTH = ("admin", "s3cret")
+
+    @pytest.fixture(autouse=True)
+    def basic_auth(self, monkeypatch):
+        monkeypatch.setenv("BASIC_AUTH_USER", "admin")
+        monkeypatch.setenv("BASIC_AUTH_PASS", "s3cret")
+
+    def test_multi_field_update(self, client, config_file, monkeypatch):
+        """Test that one update changes the status sequence, delay and drain state together"""
+        monkeypatch.setenv("STATUS_SEQUENCE", "500")
+        update = {"error_rate": 0, "response_delay_ms": 100, "status_sequence": "202,503", "draining": True}
+
+        response = client.post("/admin/config", json=update, auth=self.AUTH)
+
+        assert response.status_code == 200
+        assert response.json() == update
+        started = time.monotonic()
+        statuses = [client.get("/config", auth=self.AUTH).status_code for _ in range(3)]
+        assert time.monotonic() - started >= 0.3
+        assert statuses == [202, 503, 202]
+        assert client.get("/readyz").status_code == 503
+
+    def test_error_rate_and_reset(self, client, config_file, monkeypatch):
+        """Test that an error rate override injects errors until a null value returns to the env"""
+        monkeypatch.setenv("ERROR_RATE", "0")
+
+        client.post("/admin/config", json={"error_rate": 1}, auth=self.AUTH)
+        response = client.get("/config", auth=self.AUTH)
+
+        assert response.status_code == 500
+        assert response.text == "injected error"
+
+        settings = client.post("/admin/config", json={"error_rate": None, "draining": False}, auth=self.AUTH).json()
+
+        assert settings["error_rate"] == 0.0
+        assert client.get("/config", auth=self.AUTH).status_code == 200
+
+    def test_snapshot_pins_one_update(self):
+        """Test that an update landing mid-request is not seen until the next request"""
+        alert_app.runtime_settings.apply({"error_rate": 1, "response_delay_ms": 0})
+
+        with alert_app.runtime_settings.snapshot():
+            update = threading.Thread(
+                target=alert_app.runtime_settings.apply, args=({"error_rate": 0, "response_delay_ms": 50},)
+            )
+            update.start()
+            update.join()
+
+            assert (alert_app.get_error_rate(), alert_app.get_response_delay_ms()) == (1, 0)
+
+        assert (alert_app.get_error_rate(), alert_app.get_response_delay_ms()) == (0, 50)
+
+    def test_unknown_field_rejected(self, client, config_file):
+        """Test that an unknown field fails the whole update with 400 and changes nothing"""
+        response = client.post("/admin/config", json={"draining": True, "chaos": 11}, auth=self.AUTH)
+
+        assert response.status_code == 400
+        assert response.text == "unknown fields: chaos"
+        assert not alert_app.readiness.draining()
+
+    @pytest.mark.parametrize(
+        "update",
+        [{"error_rate": 1.5}, {"response_delay_ms": -1}, {"status_sequence": "200,teapot"}, {"draining": "yes"}, []],
+    )
+    def test_invalid_values_rejected(self, client, update):
+        """Test that out-of-range or mistyped values are a 400"""
+        assert client.post("/admin/config", json=update, auth=self.AUTH).status_code == 400
+
+    def test_requires_credentials(self, client, monkeypatch):
+        """Test that the endpoint needs the configured credentials, and basic auth at all"""
+        assert client.post("/admin/config", json={"draining": True}).status_code == 401
+
+        monkeypatch.delenv("BASIC_AUTH_PASS")
+
+        assert client.post("/admin/config", json={"draining": True}).status_code == 404
+        assert not alert_app.readiness.draining()